@@ -0,0 +1,210 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/garfieldlw/common-golang/pkg/etcd"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	client3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errNoValue = errors.New("no value in etcd")
+
+// Subscriber is called after the value changed with the previous and the new
+// value. It is not called for the initial load done by Watch.
+type Subscriber[T any] func(old, new *T)
+
+// Value is a typed configuration value loaded from one etcd key and kept up
+// to date with a watch. Keys ending in .yaml or .yml are decoded as YAML,
+// everything else as JSON.
+type Value[T any] struct {
+	etcd *etcd.Etcd
+	key  string
+
+	current atomic.Pointer[T]
+
+	// mu serializes updates so subscribers see changes in order
+	mu  sync.Mutex
+	raw []byte
+
+	// revision is the store revision seen last, the watch resumes after it.
+	// It is only used by the watch goroutine.
+	revision int64
+
+	subsMu sync.Mutex
+	subs   []Subscriber[T]
+}
+
+// Load reads key once and decodes it into a T.
+func Load[T any](ctx context.Context, e *etcd.Etcd, key string) (*T, error) {
+	data, err := e.GetBytes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return decode[T](key, data)
+}
+
+// Watch loads key and keeps the returned value updated until ctx is
+// cancelled. It fails when the initial load fails; later invalid values are
+// logged and ignored so the last good value stays in place.
+func Watch[T any](ctx context.Context, e *etcd.Etcd, key string) (*Value[T], error) {
+	v := &Value[T]{etcd: e, key: key}
+
+	// the watch starts right after the revision of the initial read, so no
+	// change falls in between
+	data, err := v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = v.set(data); err != nil {
+		return nil, err
+	}
+
+	go v.loop(ctx)
+
+	return v, nil
+}
+
+// Get returns the current value. It never blocks and must not be modified.
+func (v *Value[T]) Get() *T {
+	return v.current.Load()
+}
+
+// Subscribe registers fn for future changes.
+func (v *Value[T]) Subscribe(fn Subscriber[T]) {
+	v.subsMu.Lock()
+	defer v.subsMu.Unlock()
+
+	v.subs = append(v.subs, fn)
+}
+
+func (v *Value[T]) loop(ctx context.Context) {
+	for {
+		compacted := v.consume(ctx)
+
+		// the watch was closed: resume after the last seen revision, or read
+		// the key again when that revision was compacted away
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+
+			if !compacted {
+				break
+			}
+			data, err := v.read(ctx)
+			if err == nil {
+				v.apply(data)
+				break
+			}
+			if errors.Is(err, errNoValue) {
+				log.Warn("config key deleted, keeping last value", zap.String("key", v.key))
+				break
+			}
+			log.Warn("config reload failed", zap.String("key", v.key), zap.Error(err))
+		}
+	}
+}
+
+// consume applies the changes of one watch and reports whether it ended
+// because the next revision was compacted.
+func (v *Value[T]) consume(ctx context.Context) bool {
+	watchCtx, cancel := context.WithCancel(client3.WithRequireLeader(ctx))
+	defer cancel()
+
+	wch := v.etcd.GetClient().Watch(watchCtx, v.key, client3.WithRev(v.revision+1))
+	for resp := range wch {
+		if resp.CompactRevision != 0 {
+			log.Warn("config watch revision compacted", zap.String("key", v.key), zap.Int64("revision", resp.CompactRevision))
+			return true
+		}
+		if err := resp.Err(); err != nil {
+			log.Warn("config watch failed", zap.String("key", v.key), zap.Error(err))
+			return false
+		}
+
+		for _, ev := range resp.Events {
+			if ev.Type == client3.EventTypeDelete {
+				log.Warn("config key deleted, keeping last value", zap.String("key", v.key))
+			} else {
+				v.apply(ev.Kv.Value)
+			}
+			v.revision = ev.Kv.ModRevision
+		}
+	}
+	return false
+}
+
+// read gets the value of the key and the store revision to watch from.
+func (v *Value[T]) read(ctx context.Context) ([]byte, error) {
+	res, err := v.etcd.Txn().ThenGet(v.key).Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	v.revision = res.Revision
+	if len(res.Items) == 0 {
+		return nil, errNoValue
+	}
+	return []byte(res.Items[0].Value), nil
+}
+
+func (v *Value[T]) apply(data []byte) {
+	if err := v.set(data); err != nil {
+		log.Error("config value is invalid, keeping last value", zap.String("key", v.key), zap.Error(err))
+	}
+}
+
+func (v *Value[T]) set(data []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.current.Load() != nil && bytes.Equal(v.raw, data) {
+		return nil
+	}
+
+	next, err := decode[T](v.key, data)
+	if err != nil {
+		return err
+	}
+
+	old := v.current.Swap(next)
+	v.raw = data
+
+	v.subsMu.Lock()
+	subs := append([]Subscriber[T](nil), v.subs...)
+	v.subsMu.Unlock()
+
+	for _, fn := range subs {
+		fn(old, next)
+	}
+	return nil
+}
+
+func decode[T any](key string, data []byte) (*T, error) {
+	if len(data) == 0 {
+		return nil, errors.New("config value is empty")
+	}
+
+	v := new(T)
+	var err error
+	if strings.HasSuffix(key, ".yaml") || strings.HasSuffix(key, ".yml") {
+		err = yaml.Unmarshal(data, v)
+	} else {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,452 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Driver builds the gorm dialector for a data source name. The mysql,
+// postgres and sqlite3 packages register theirs when imported.
+type Driver func(dsn string) gorm.Dialector
+
+var driversLock = &sync.RWMutex{}
+var drivers = map[string]Driver{}
+
+// RegisterDriver makes a driver available to Open under name.
+func RegisterDriver(name string, driver Driver) {
+	driversLock.Lock()
+	defer driversLock.Unlock()
+
+	drivers[name] = driver
+}
+
+func getDriver(name string) (Driver, error) {
+	driversLock.RLock()
+	defer driversLock.RUnlock()
+
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, errors.New("unknown db driver " + name + ", forgot to import it?")
+	}
+	return driver, nil
+}
+
+type Config struct {
+	Driver string `json:"driver"`
+	DSN    string `json:"dsn"`
+	// Open and Idle are the pool sizes, 20 and 5 by default.
+	Open int64 `json:"open"`
+	Idle int64 `json:"idle"`
+	// MaxLifetime and MaxIdleTime in seconds; connections live 3300 seconds
+	// and may stay idle forever by default.
+	MaxLifetime int64 `json:"max_lifetime"`
+	MaxIdleTime int64 `json:"max_idle_time"`
+	// Retries is how often GetDB retries a failed connection, 3 by default.
+	Retries int64 `json:"retries"`
+	// TxRetries is how often WithTx reruns a transaction that deadlocked,
+	// 3 by default.
+	TxRetries int64 `json:"tx_retries"`
+	// Replicas are the DSNs reads are spread over by Policy, random by
+	// default. Resolvers route some tables or models elsewhere.
+	Replicas  []string   `json:"replicas"`
+	Policy    string     `json:"policy"`
+	Resolvers []Resolver `json:"resolvers"`
+	// SlowThreshold in milliseconds above which statements are logged as
+	// slow, 200 by default. LogParams logs the statement parameters, which
+	// may hold personal data; see LoggerConfig.LogParams for what is logged
+	// without it.
+	SlowThreshold int64 `json:"slow_threshold"`
+	LogParams     bool  `json:"log_params"`
+}
+
+type State int32
+
+const (
+	// StateIdle is a pool that has not connected yet.
+	StateIdle State = iota
+	StateReady
+	// StateFailed is a pool whose last connection attempt or ping failed.
+	StateFailed
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateReady:
+		return "ready"
+	case StateFailed:
+		return "failed"
+	case StateClosed:
+		return "closed"
+	default:
+		return "idle"
+	}
+}
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// DB is a named connection pool. It connects on first use and reconnects
+// with backoff while the database is unreachable.
+type DB struct {
+	name string
+	conf *Config
+
+	conn  atomic.Pointer[gorm.DB]
+	state atomic.Int32
+
+	// sem serializes connection attempts, unlike a mutex callers can stop
+	// waiting for it when their ctx is done. It is not held while backing
+	// off, so a caller is never stuck behind another one's retries.
+	sem    chan struct{}
+	closed bool
+
+	errMu    sync.Mutex
+	lastErr  error
+	failures int
+	retryAt  time.Time
+
+	metrics *Metrics
+}
+
+var lock = &sync.Mutex{}
+var instances = map[string]*DB{}
+
+// Register registers a pool under name without connecting, replacing and
+// closing the one registered before under the same name. The connection is
+// made by the first GetDB.
+func Register(name string, conf *Config) (*DB, error) {
+	if conf == nil {
+		return nil, errors.New("db config is invalid")
+	}
+	if _, err := getDriver(conf.Driver); err != nil {
+		return nil, err
+	}
+
+	d := &DB{name: name, conf: conf, sem: make(chan struct{}, 1), metrics: NewMetrics()}
+
+	lock.Lock()
+	old := instances[name]
+	instances[name] = d
+	lock.Unlock()
+
+	if old != nil {
+		_ = old.Close()
+	}
+
+	return d, nil
+}
+
+// Open registers a pool under name like Register and connects it. When the
+// connection fails the pool stays registered, so a later GetDB retries.
+func Open(name string, conf *Config) (*DB, error) {
+	d, err := Register(name, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = d.GetDB(context.Background()); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *DB) connect() (*gorm.DB, error) {
+	driver, err := getDriver(d.conf.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := gorm.Open(driver(d.conf.DSN), &gorm.Config{
+		Logger: NewLogger(LoggerConfig{
+			SlowThreshold: time.Duration(d.conf.SlowThreshold) * time.Millisecond,
+			LogParams:     d.conf.LogParams,
+		}),
+	})
+	if err != nil {
+		// gorm keeps the pool open when only the initial ping failed
+		if conn != nil {
+			if sqlDB, e := conn.DB(); e == nil {
+				_ = sqlDB.Close()
+			}
+		}
+		return nil, err
+	}
+
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return nil, err
+	}
+	tune(sqlDB, d.conf)
+
+	if err = useResolver(conn, driver, d.conf); err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	if err = conn.Use(d.metrics); err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+
+	return conn.Session(&gorm.Session{}), nil
+}
+
+func tune(sqlDB *sql.DB, conf *Config) {
+	open, idle, lifetime := poolSizes(conf)
+
+	sqlDB.SetMaxOpenConns(int(open))
+	sqlDB.SetMaxIdleConns(int(idle))
+	sqlDB.SetConnMaxLifetime(lifetime)
+	sqlDB.SetConnMaxIdleTime(time.Duration(conf.MaxIdleTime) * time.Second)
+}
+
+func poolSizes(conf *Config) (int64, int64, time.Duration) {
+	open, idle := conf.Open, conf.Idle
+	if open == 0 {
+		open = 20
+	}
+	if idle == 0 {
+		idle = 5
+	}
+	lifetime := time.Duration(conf.MaxLifetime) * time.Second
+	if lifetime == 0 {
+		lifetime = 3300 * time.Second
+	}
+	return open, idle, lifetime
+}
+
+// Get returns the pool registered under name.
+func Get(name string) (*DB, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	d, ok := instances[name]
+	if !ok {
+		return nil, errors.New("db " + name + " is not opened")
+	}
+	return d, nil
+}
+
+// Close closes the pool registered under name.
+func Close(name string) error {
+	lock.Lock()
+	d, ok := instances[name]
+	delete(instances, name)
+	lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return d.Close()
+}
+
+func (d *DB) Name() string {
+	return d.name
+}
+
+func (d *DB) Config() *Config {
+	return d.conf
+}
+
+// GetDB returns the gorm handle bound to ctx, connecting first if needed.
+// Within WithTx it returns the transaction. A failed connection is retried
+// with backoff within ctx; after all retries failed, calls return the last
+// error right away until the backoff passed.
+func (d *DB) GetDB(ctx context.Context) (*gorm.DB, error) {
+	if tx, ok := d.txFromContext(ctx); ok {
+		return tx.WithContext(ctx), nil
+	}
+	if conn := d.conn.Load(); conn != nil {
+		return conn.WithContext(ctx), nil
+	}
+
+	retries := d.conf.Retries
+	if retries <= 0 {
+		retries = 3
+	}
+
+	for attempt := 0; ; attempt++ {
+		conn, retry, err := d.attempt(ctx, attempt)
+		if err == nil {
+			return conn.WithContext(ctx), nil
+		}
+		if !retry {
+			return nil, err
+		}
+
+		if int64(attempt) >= retries || !sleepContext(ctx, backoff(attempt)) {
+			d.errMu.Lock()
+			d.failures++
+			d.retryAt = time.Now().Add(backoff(d.failures))
+			d.errMu.Unlock()
+
+			return nil, err
+		}
+	}
+}
+
+// attempt connects once under sem. retry reports whether the error came from
+// the database, not from ctx, a closed pool or a pending backoff.
+func (d *DB) attempt(ctx context.Context, n int) (*gorm.DB, bool, error) {
+	select {
+	case d.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	defer func() { <-d.sem }()
+
+	if d.closed {
+		return nil, false, errors.New("db " + d.name + " is closed")
+	}
+	if conn := d.conn.Load(); conn != nil {
+		return conn, false, nil
+	}
+
+	d.errMu.Lock()
+	waiting := n == 0 && time.Now().Before(d.retryAt)
+	lastErr := d.lastErr
+	d.errMu.Unlock()
+	if waiting {
+		if lastErr == nil {
+			lastErr = errors.New("db " + d.name + " is not connected")
+		}
+		return nil, false, lastErr
+	}
+
+	conn, err := d.connect()
+	if err != nil {
+		d.setState(StateFailed, err)
+		log.Warn("db connect failed", zap.String("db", d.name), zap.Int("attempt", n+1), zap.Error(err))
+		return nil, true, err
+	}
+
+	d.conn.Store(conn)
+	d.errMu.Lock()
+	d.failures = 0
+	d.errMu.Unlock()
+	d.setState(StateReady, nil)
+	return conn, false, nil
+}
+
+// Conn returns the gorm handle, nil while not connected.
+//
+// Deprecated: use GetDB, which connects and reports why it could not.
+func (d *DB) Conn() *gorm.DB {
+	return d.conn.Load()
+}
+
+// State is the readiness of the pool, for health checks.
+func (d *DB) State() State {
+	return State(d.state.Load())
+}
+
+func (d *DB) Ready() bool {
+	return d.State() == StateReady
+}
+
+// Err returns the error of the last failed connection attempt or ping, nil
+// once the pool is ready again.
+func (d *DB) Err() error {
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
+
+	return d.lastErr
+}
+
+func (d *DB) setState(state State, err error) {
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
+
+	d.state.Store(int32(state))
+	d.lastErr = err
+}
+
+// Ping checks the database and updates the readiness state.
+func (d *DB) Ping(ctx context.Context) error {
+	conn, err := d.GetDB(ctx)
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	if err = sqlDB.PingContext(ctx); err != nil {
+		d.setState(StateFailed, err)
+		return err
+	}
+
+	d.setState(StateReady, nil)
+	return nil
+}
+
+// Stats returns the statistics of the primary pool.
+func (d *DB) Stats() sql.DBStats {
+	conn := d.conn.Load()
+	if conn == nil {
+		return sql.DBStats{}
+	}
+
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return sql.DBStats{}
+	}
+	return sqlDB.Stats()
+}
+
+// Operations returns the statement counters, meant to be read periodically
+// by a metrics exporter, see Metrics.Snapshot.
+func (d *DB) Operations() map[string]OperationStats {
+	return d.metrics.Snapshot()
+}
+
+func (d *DB) Close() error {
+	d.sem <- struct{}{}
+	defer func() { <-d.sem }()
+
+	d.closed = true
+	d.setState(StateClosed, nil)
+
+	conn := d.conn.Swap(nil)
+	if conn == nil {
+		return nil
+	}
+
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func backoff(attempt int) time.Duration {
+	if attempt > 10 {
+		return maxBackoff
+	}
+	if d := minBackoff << attempt; d < maxBackoff {
+		return d
+	}
+	return maxBackoff
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
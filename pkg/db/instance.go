@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"sync"
+	"sync/atomic"
+)
+
+// Instance is the pool a driver package like mysql exposes through package
+// level functions. C is the configuration type of the package, converted to
+// a Config by config. The pool is registered on first use and replaced when
+// SetConfig is called.
+type Instance[C any] struct {
+	name     string
+	config   func(conf *C) *Config
+	defaults func() *C
+
+	conf atomic.Pointer[C]
+	info atomic.Pointer[Info[C]]
+	lock sync.Mutex
+}
+
+// NewInstance returns the instance registered under name, using defaults
+// until SetConfig is called.
+func NewInstance[C any](name string, config func(conf *C) *Config, defaults func() *C) *Instance[C] {
+	return &Instance[C]{name: name, config: config, defaults: defaults}
+}
+
+// Info is the handle returned by the deprecated LoadDb of driver packages.
+type Info[C any] struct {
+	DbConfig *C
+	Conn     *gorm.DB
+
+	db *DB
+	// mu guards Conn, apart from the instance lock so a slow connection does
+	// not block the other calls
+	mu sync.Mutex
+}
+
+// SetConfig replaces the configuration, e.g. from a config.Value subscriber.
+// The current pool is closed and the next call connects with conf.
+func (i *Instance[C]) SetConfig(conf *C) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.conf.Store(conf)
+	_ = Close(i.name)
+	i.info.Store(nil)
+}
+
+// Config returns the configuration set last, or the defaults.
+func (i *Instance[C]) Config() *C {
+	if conf := i.conf.Load(); conf != nil {
+		return conf
+	}
+	return i.defaults()
+}
+
+func (i *Instance[C]) load() (*Info[C], error) {
+	if info := i.info.Load(); info != nil {
+		return info, nil
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if info := i.info.Load(); info != nil {
+		return info, nil
+	}
+
+	conf := i.Config()
+	if conf == nil {
+		return nil, errors.New(i.name + " config is invalid")
+	}
+
+	d, err := Register(i.name, i.config(conf))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info[C]{DbConfig: conf, db: d}
+	i.info.Store(info)
+	return info, nil
+}
+
+// DB returns the pool, registering it first if needed.
+func (i *Instance[C]) DB() (*DB, error) {
+	info, err := i.load()
+	if err != nil {
+		return nil, err
+	}
+	return info.db, nil
+}
+
+// GetDB returns the gorm handle bound to ctx, connecting first if needed,
+// or the reason it could not connect.
+func (i *Instance[C]) GetDB(ctx context.Context) (*gorm.DB, error) {
+	d, err := i.DB()
+	if err != nil {
+		return nil, err
+	}
+	return d.GetDB(ctx)
+}
+
+// State is the readiness of the pool, for health checks.
+func (i *Instance[C]) State() State {
+	d, err := i.DB()
+	if err != nil {
+		return StateFailed
+	}
+	return d.State()
+}
+
+// Err returns the error of the last failed connection attempt or ping.
+func (i *Instance[C]) Err() error {
+	d, err := i.DB()
+	if err != nil {
+		return err
+	}
+	return d.Err()
+}
+
+// WithTx runs fn in a transaction, see DB.WithTx.
+func (i *Instance[C]) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	d, err := i.DB()
+	if err != nil {
+		return err
+	}
+	return d.WithTx(ctx, fn)
+}
+
+func (i *Instance[C]) Ping(ctx context.Context) error {
+	d, err := i.DB()
+	if err != nil {
+		return err
+	}
+	return d.Ping(ctx)
+}
+
+// Deprecated: use GetDB, which reports why it could not connect.
+func (i *Instance[C]) LoadDb() *Info[C] {
+	info, err := i.load()
+	if err != nil {
+		return nil
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.Conn == nil {
+		info.InitConnect()
+	}
+
+	return info
+}
+
+// Deprecated: use GetDB, which reports why it could not connect.
+func (i *Instance[C]) GetDb() *gorm.DB {
+	conn, err := i.GetDB(context.Background())
+	if err != nil {
+		return nil
+	}
+	return conn
+}
+
+// Deprecated: use GetDB.
+func (info *Info[C]) InitConnect() {
+	info.Conn, _ = info.db.GetDB(context.Background())
+}
+
+// Deprecated: use GetDB.
+func (info *Info[C]) CheckAndReturnConn() *gorm.DB {
+	conn, err := info.db.GetDB(context.Background())
+	if err != nil {
+		return nil
+	}
+	return conn
+}
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultSlowThreshold = 200 * time.Millisecond
+
+// LoggerConfig controls the gorm logger.
+type LoggerConfig struct {
+	// SlowThreshold is the latency above which a statement is logged.
+	SlowThreshold time.Duration
+	// LogParams logs statements with their parameters. By default they are
+	// logged with placeholders only. Failed statements are logged with the
+	// error of the driver as is, which may still quote values, e.g. the
+	// duplicate key of a MySQL unique violation.
+	LogParams bool
+	// Level is the gorm log level, logger.Warn by default. At logger.Info
+	// every statement is logged at debug level.
+	Level logger.LogLevel
+}
+
+// Logger writes the gorm logs through pkg/log.
+type Logger struct {
+	conf LoggerConfig
+}
+
+var _ logger.Interface = (*Logger)(nil)
+var _ gorm.ParamsFilter = (*Logger)(nil)
+
+func NewLogger(conf LoggerConfig) *Logger {
+	if conf.SlowThreshold <= 0 {
+		conf.SlowThreshold = defaultSlowThreshold
+	}
+	if conf.Level == 0 {
+		conf.Level = logger.Warn
+	}
+	return &Logger{conf: conf}
+}
+
+func (l *Logger) LogMode(level logger.LogLevel) logger.Interface {
+	c := *l
+	c.conf.Level = level
+	return &c
+}
+
+func (l *Logger) Info(ctx context.Context, msg string, data ...any) {
+	if l.conf.Level >= logger.Info {
+		log.Info(fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l *Logger) Warn(ctx context.Context, msg string, data ...any) {
+	if l.conf.Level >= logger.Warn {
+		log.Warn(fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l *Logger) Error(ctx context.Context, msg string, data ...any) {
+	if l.conf.Level >= logger.Error {
+		log.Error(fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l *Logger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.conf.Level <= logger.Silent {
+		return
+	}
+
+	cost := time.Since(begin)
+	switch {
+	case err != nil && l.conf.Level >= logger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		query, rows := fc()
+		log.Error("sql failed", zap.String("sql", query), zap.Int64("rows", rows), zap.Duration("cost", cost), zap.Error(err))
+	case cost >= l.conf.SlowThreshold && l.conf.Level >= logger.Warn:
+		query, rows := fc()
+		log.Warn("sql slow", zap.String("sql", query), zap.Int64("rows", rows), zap.Duration("cost", cost))
+	case l.conf.Level >= logger.Info:
+		query, rows := fc()
+		log.Debug("sql", zap.String("sql", query), zap.Int64("rows", rows), zap.Duration("cost", cost))
+	}
+}
+
+// ParamsFilter drops the parameters unless LogParams is set, so they are not
+// inlined into the logged statements.
+func (l *Logger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	if l.conf.LogParams {
+		return sql, params
+	}
+	return sql, nil
+}
+
+// OperationStats are the counters kept per operation and table.
+type OperationStats struct {
+	Calls        int64         `json:"calls"`
+	Errors       int64         `json:"errors"`
+	TotalLatency time.Duration `json:"total_latency"`
+	MaxLatency   time.Duration `json:"max_latency"`
+}
+
+// Tracer starts a span for a statement, e.g. an OpenTelemetry span, and
+// returns the context carrying it, which the statement runs with, and the
+// func ending it. op is create, query, update, delete, row or raw; raw
+// statements have no table. err is the error of the statement as is.
+type Tracer func(ctx context.Context, op, table string) (context.Context, func(err error))
+
+var tracer atomic.Pointer[Tracer]
+
+// SetTracer traces the statements of every pool with t, nil turns tracing
+// off.
+func SetTracer(t Tracer) {
+	if t == nil {
+		tracer.Store(nil)
+		return
+	}
+	tracer.Store(&t)
+}
+
+// Metrics is a gorm plugin counting latency and errors per operation and
+// table, and tracing the statements with the Tracer set by SetTracer.
+type Metrics struct {
+	mu         sync.Mutex
+	operations map[string]*OperationStats
+}
+
+var _ gorm.Plugin = (*Metrics)(nil)
+
+const (
+	metricsStartKey = "db:metrics_start"
+	metricsEndKey   = "db:metrics_end"
+)
+
+func NewMetrics() *Metrics {
+	return &Metrics{operations: make(map[string]*OperationStats)}
+}
+
+func (m *Metrics) Name() string {
+	return "db:metrics"
+}
+
+func (m *Metrics) Initialize(conn *gorm.DB) error {
+	cb := conn.Callback()
+	for _, err := range []error{
+		cb.Create().Before("*").Register("db:metrics_before", m.before("create")),
+		cb.Create().After("*").Register("db:metrics_after", m.after("create")),
+		cb.Query().Before("*").Register("db:metrics_before", m.before("query")),
+		cb.Query().After("*").Register("db:metrics_after", m.after("query")),
+		cb.Update().Before("*").Register("db:metrics_before", m.before("update")),
+		cb.Update().After("*").Register("db:metrics_after", m.after("update")),
+		cb.Delete().Before("*").Register("db:metrics_before", m.before("delete")),
+		cb.Delete().After("*").Register("db:metrics_after", m.after("delete")),
+		cb.Row().Before("*").Register("db:metrics_before", m.before("row")),
+		cb.Row().After("*").Register("db:metrics_after", m.after("row")),
+		cb.Raw().Before("*").Register("db:metrics_before", m.before("raw")),
+		cb.Raw().After("*").Register("db:metrics_after", m.after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Metrics) before(op string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		// the model is parsed before the callbacks run, so the table is known
+		if t := tracer.Load(); t != nil {
+			ctx, end := (*t)(tx.Statement.Context, op, tx.Statement.Table)
+			if ctx != nil {
+				tx.Statement.Context = ctx
+			}
+			if end != nil {
+				tx.InstanceSet(metricsEndKey, end)
+			}
+		}
+		tx.InstanceSet(metricsStartKey, time.Now())
+	}
+}
+
+func (m *Metrics) after(op string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		if value, ok := tx.InstanceGet(metricsEndKey); ok {
+			if end, ok := value.(func(err error)); ok {
+				end(tx.Error)
+			}
+		}
+
+		value, ok := tx.InstanceGet(metricsStartKey)
+		if !ok {
+			return
+		}
+		start, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+
+		name := op
+		if len(tx.Statement.Table) > 0 {
+			name += " " + tx.Statement.Table
+		}
+		failed := tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound)
+		m.record(name, time.Since(start), failed)
+	}
+}
+
+func (m *Metrics) record(name string, cost time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.operations[name]
+	if !ok {
+		stats = new(OperationStats)
+		m.operations[name] = stats
+	}
+
+	stats.Calls++
+	if failed {
+		stats.Errors++
+	}
+	stats.TotalLatency += cost
+	if cost > stats.MaxLatency {
+		stats.MaxLatency = cost
+	}
+}
+
+// Snapshot copies the counters, keyed by "<operation> <table>", e.g. "query
+// users"; raw statements have no table.
+func (m *Metrics) Snapshot() map[string]OperationStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]OperationStats, len(m.operations))
+	for name, stats := range m.operations {
+		snapshot[name] = *stats
+	}
+	return snapshot
+}
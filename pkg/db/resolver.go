@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	PolicyRandom     = "random"
+	PolicyRoundRobin = "round_robin"
+	PolicyLeastConn  = "least_conn"
+)
+
+// Resolver routes the statements on some tables or models to their own
+// sources and replicas.
+type Resolver struct {
+	Tables []string `json:"tables"`
+	Models []any    `json:"-"`
+	// Sources are the DSNs of the primaries, the main database when empty.
+	Sources  []string `json:"sources"`
+	Replicas []string `json:"replicas"`
+	Policy   string   `json:"policy"`
+}
+
+// useResolver sends reads to the replicas and writes to the primary. A single
+// statement is forced to the primary with Clauses(dbresolver.Write), all reads
+// of a request with Primary or Sticky.
+func useResolver(conn *gorm.DB, driver Driver, conf *Config) error {
+	if len(conf.Replicas) == 0 && len(conf.Resolvers) == 0 {
+		return nil
+	}
+
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors(driver, conf.Replicas),
+		Policy:   policy(conf.Policy),
+	})
+	for _, r := range conf.Resolvers {
+		datas := make([]any, 0, len(r.Tables)+len(r.Models))
+		for _, table := range r.Tables {
+			datas = append(datas, table)
+		}
+		datas = append(datas, r.Models...)
+
+		resolver = resolver.Register(dbresolver.Config{
+			Sources:  dialectors(driver, r.Sources),
+			Replicas: dialectors(driver, r.Replicas),
+			Policy:   policy(r.Policy),
+		}, datas...)
+	}
+
+	open, idle, lifetime := poolSizes(conf)
+	resolver.SetMaxOpenConns(int(open)).
+		SetMaxIdleConns(int(idle)).
+		SetConnMaxLifetime(lifetime).
+		SetConnMaxIdleTime(time.Duration(conf.MaxIdleTime) * time.Second)
+
+	if err := conn.Use(resolver); err != nil {
+		return err
+	}
+	return registerSticky(conn)
+}
+
+func dialectors(driver Driver, dsns []string) []gorm.Dialector {
+	list := make([]gorm.Dialector, 0, len(dsns))
+	for _, dsn := range dsns {
+		list = append(list, driver(dsn))
+	}
+	return list
+}
+
+func policy(name string) dbresolver.Policy {
+	switch strings.ToLower(name) {
+	case PolicyRoundRobin:
+		return &roundRobinPolicy{}
+	case PolicyLeastConn:
+		return leastConnPolicy{}
+	default:
+		return dbresolver.RandomPolicy{}
+	}
+}
+
+type roundRobinPolicy struct {
+	next atomic.Uint64
+}
+
+func (p *roundRobinPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	return connPools[(p.next.Add(1)-1)%uint64(len(connPools))]
+}
+
+// leastConnPolicy picks the replica with the fewest connections in use.
+type leastConnPolicy struct{}
+
+func (leastConnPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	best, inUse := -1, 0
+	for i, pool := range connPools {
+		sqlDB, ok := pool.(*sql.DB)
+		if !ok {
+			continue
+		}
+		if n := sqlDB.Stats().InUse; best < 0 || n < inUse {
+			best, inUse = i, n
+		}
+	}
+	if best < 0 {
+		return connPools[rand.Intn(len(connPools))]
+	}
+	return connPools[best]
+}
+
+type stickyKey struct{}
+
+// Sticky returns a context whose reads go to the primary once a write was
+// made with it, so a request reads its own writes despite replication lag.
+func Sticky(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(stickyKey{}).(*atomic.Bool); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, stickyKey{}, &atomic.Bool{})
+}
+
+// Primary returns a context whose reads all go to the primary.
+func Primary(ctx context.Context) context.Context {
+	wrote := &atomic.Bool{}
+	wrote.Store(true)
+	return context.WithValue(ctx, stickyKey{}, wrote)
+}
+
+// StickyMiddleware makes the context of every request Sticky.
+func StickyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(Sticky(r.Context())))
+	})
+}
+
+func registerSticky(conn *gorm.DB) error {
+	cb := conn.Callback()
+	if err := cb.Query().Before("gorm:query").Register("db:sticky_read", stickyRead); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("db:sticky_read", stickyRead); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register("db:sticky_read", stickyRead); err != nil {
+		return err
+	}
+	if err := cb.Create().After("gorm:create").Register("db:sticky_write", stickyWrite); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("db:sticky_write", stickyWrite); err != nil {
+		return err
+	}
+	if err := cb.Delete().After("gorm:delete").Register("db:sticky_write", stickyWrite); err != nil {
+		return err
+	}
+	return cb.Raw().After("gorm:raw").Register("db:sticky_write", stickyWrite)
+}
+
+func stickyFlag(tx *gorm.DB) *atomic.Bool {
+	if tx.Statement.Context == nil {
+		return nil
+	}
+	wrote, _ := tx.Statement.Context.Value(stickyKey{}).(*atomic.Bool)
+	return wrote
+}
+
+func stickyRead(tx *gorm.DB) {
+	if wrote := stickyFlag(tx); wrote != nil && wrote.Load() {
+		dbresolver.Write.ModifyStatement(tx.Statement)
+	}
+}
+
+func stickyWrite(tx *gorm.DB) {
+	wrote := stickyFlag(tx)
+	if wrote == nil {
+		return
+	}
+
+	// Raw serves both, only statements other than a select are writes
+	if query := strings.TrimSpace(tx.Statement.SQL.String()); len(query) >= 6 && strings.EqualFold(query[:6], "select") {
+		return
+	}
+	wrote.Store(true)
+}
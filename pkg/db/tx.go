@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var retryableLock = &sync.RWMutex{}
+var retryables = map[string]func(err error) bool{}
+
+// RegisterRetryable tells WithTx which errors of a driver abort a transaction
+// that succeeds when run again, e.g. deadlocks and serialization failures.
+func RegisterRetryable(driver string, fn func(err error) bool) {
+	retryableLock.Lock()
+	defer retryableLock.Unlock()
+
+	retryables[driver] = fn
+}
+
+func (d *DB) retryable(err error) bool {
+	retryableLock.RLock()
+	fn := retryables[d.conf.Driver]
+	retryableLock.RUnlock()
+
+	return fn != nil && fn(err)
+}
+
+type txKey struct{}
+
+type txState struct {
+	db    *DB
+	tx    *gorm.DB
+	depth int
+}
+
+// WithTx runs fn in a transaction that is committed when fn returns nil and
+// rolled back otherwise. GetDB with the context passed to fn returns the
+// transaction, so repositories join it without passing it around. A nested
+// WithTx becomes a savepoint of the outer transaction. When the transaction
+// failed on a deadlock or serialization failure, fn runs again with backoff,
+// so it must not have side effects outside the database.
+func (d *DB) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return d.WithTxOptions(ctx, nil, fn)
+}
+
+// WithTxOptions is WithTx with isolation level or read-only set by opts. They
+// are ignored for nested calls.
+func (d *DB) WithTxOptions(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if st, ok := ctx.Value(txKey{}).(*txState); ok && st.db == d {
+		return d.savepoint(ctx, st, fn)
+	}
+
+	conn, err := d.GetDB(ctx)
+	if err != nil {
+		return err
+	}
+
+	retries := d.conf.TxRetries
+	if retries <= 0 {
+		retries = 3
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = conn.Transaction(func(tx *gorm.DB) error {
+			return fn(context.WithValue(ctx, txKey{}, &txState{db: d, tx: tx}))
+		}, opts)
+		if err == nil || !d.retryable(err) || int64(attempt) >= retries {
+			return err
+		}
+
+		if !sleepContext(ctx, txBackoff(attempt)) {
+			return err
+		}
+	}
+}
+
+func (d *DB) savepoint(ctx context.Context, st *txState, fn func(ctx context.Context) error) error {
+	nested := &txState{db: d, tx: st.tx, depth: st.depth + 1}
+	name := fmt.Sprintf("sp%d", nested.depth)
+
+	if err := st.tx.SavePoint(name).Error; err != nil {
+		return err
+	}
+
+	if err := fn(context.WithValue(ctx, txKey{}, nested)); err != nil {
+		// a deadlock rolls back the whole transaction on MySQL, the savepoint
+		// is gone then; err must survive for the retry of the outer WithTx
+		if rbErr := st.tx.RollbackTo(name).Error; rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// txFromContext returns the transaction of d that ctx runs in.
+func (d *DB) txFromContext(ctx context.Context) (*gorm.DB, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	st, ok := ctx.Value(txKey{}).(*txState)
+	if !ok || st.db != d {
+		return nil, false
+	}
+	return st.tx, true
+}
+
+// txBackoff is short and jittered, transactions that deadlocked together
+// should not retry in lockstep.
+func txBackoff(attempt int) time.Duration {
+	if attempt > 6 {
+		attempt = 6
+	}
+	base := 10 * time.Millisecond << attempt
+	return base + time.Duration(rand.Int63n(int64(base)))
+}
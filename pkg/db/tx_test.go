@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+)
+
+var errTestDeadlock = errors.New("deadlock")
+
+// testDialector reports failed savepoint rollbacks, the sqlite driver
+// ignores them unlike the mysql and postgres ones.
+type testDialector struct {
+	gorm.Dialector
+}
+
+func (d testDialector) SavePoint(tx *gorm.DB, name string) error {
+	return tx.Exec("SAVEPOINT " + name).Error
+}
+
+func (d testDialector) RollbackTo(tx *gorm.DB, name string) error {
+	return tx.Exec("ROLLBACK TO SAVEPOINT " + name).Error
+}
+
+func init() {
+	RegisterDriver("sqlite3-test", func(dsn string) gorm.Dialector {
+		return testDialector{Dialector: sqlite.Open(dsn)}
+	})
+	RegisterRetryable("sqlite3-test", func(err error) bool {
+		return errors.Is(err, errTestDeadlock)
+	})
+}
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	d, err := Open(t.Name(), &Config{Driver: "sqlite3-test", DSN: filepath.Join(t.TempDir(), "tx.db"), Open: 1, Idle: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = Close(t.Name()) })
+
+	conn, err := d.GetDB(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Exec("CREATE TABLE items (name TEXT)").Error; err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func countItems(t *testing.T, d *DB) int64 {
+	t.Helper()
+
+	conn, err := d.GetDB(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	if err = conn.Raw("SELECT COUNT(*) FROM items").Scan(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestNestedDeadlockRetried(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDB(t)
+
+	attempts := 0
+	err := d.WithTx(ctx, func(ctx context.Context) error {
+		attempts++
+
+		tx, err := d.GetDB(ctx)
+		if err != nil {
+			return err
+		}
+		if err = tx.Exec("INSERT INTO items (name) VALUES ('outer')").Error; err != nil {
+			return err
+		}
+
+		return d.WithTx(ctx, func(ctx context.Context) error {
+			if attempts > 1 {
+				return nil
+			}
+
+			// like a MySQL deadlock, the whole transaction is rolled back and
+			// the savepoint is gone
+			tx, err := d.GetDB(ctx)
+			if err != nil {
+				return err
+			}
+			if err = tx.Exec("ROLLBACK").Error; err != nil {
+				return err
+			}
+			return errTestDeadlock
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTx = %v, want the retry to succeed", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("transaction ran %d times, want 2", attempts)
+	}
+	if n := countItems(t, d); n != 1 {
+		t.Fatalf("%d rows committed, want 1", n)
+	}
+}
+
+func TestNestedRollback(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDB(t)
+
+	failed := errors.New("failed")
+	err := d.WithTx(ctx, func(ctx context.Context) error {
+		tx, err := d.GetDB(ctx)
+		if err != nil {
+			return err
+		}
+		if err = tx.Exec("INSERT INTO items (name) VALUES ('outer')").Error; err != nil {
+			return err
+		}
+
+		err = d.WithTx(ctx, func(ctx context.Context) error {
+			tx, err := d.GetDB(ctx)
+			if err != nil {
+				return err
+			}
+			if err = tx.Exec("INSERT INTO items (name) VALUES ('inner')").Error; err != nil {
+				return err
+			}
+			return failed
+		})
+		if !errors.Is(err, failed) {
+			t.Errorf("nested WithTx = %v, want its error", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countItems(t, d); n != 1 {
+		t.Fatalf("%d rows committed, want only the outer one", n)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/olivere/elastic/v7"
 	"go.uber.org/zap"
 	"sync"
+	"sync/atomic"
 )
 
 type Index string
@@ -17,6 +18,7 @@ const (
 
 var lock = &sync.Mutex{}
 var search *ElasticSearch
+var elasticConfig atomic.Pointer[ElasticsearchConfigItem]
 
 type ElasticSearch struct {
 	client *elastic.Client
@@ -152,7 +154,25 @@ type ElasticsearchConfigItem struct {
 	Password string `json:"password"`
 }
 
+// SetConfig replaces the elasticsearch configuration, e.g. from a
+// config.Value subscriber. The next NewElasticClient call builds a new client
+// with conf.
+func SetConfig(conf *ElasticsearchConfigItem) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	elasticConfig.Store(conf)
+	if search != nil {
+		search.client.Stop()
+		search = nil
+	}
+}
+
 func GetElasticsearchConfig() *ElasticsearchConfigItem {
+	if conf := elasticConfig.Load(); conf != nil {
+		return conf
+	}
+
 	return &ElasticsearchConfigItem{
 		Host:     "10.0.0.1",
 		Port:     9000,
@@ -0,0 +1,153 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	recipe "go.etcd.io/etcd/client/v3/experimental/recipes"
+)
+
+var ErrNoLeader = errors.New("etcd election has no leader")
+
+// concurrencySession adapts the session lease to the etcd concurrency
+// package, so locks and elections are released together with the session.
+func (s *Session) concurrencySession() (*concurrency.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errors.New("etcd session closed")
+	}
+	if s.cs == nil {
+		cs, err := concurrency.NewSession(s.etcd.cli, concurrency.WithLease(s.id))
+		if err != nil {
+			return nil, err
+		}
+		s.cs = cs
+	}
+	return s.cs, nil
+}
+
+// Mutex is a distributed lock held through a session lease. It is released
+// by Unlock or when the lease is lost.
+type Mutex struct {
+	mu *concurrency.Mutex
+}
+
+func (s *Session) NewMutex(key string) (*Mutex, error) {
+	cs, err := s.concurrencySession()
+	if err != nil {
+		return nil, err
+	}
+	return &Mutex{mu: concurrency.NewMutex(cs, key)}, nil
+}
+
+// Lock blocks until the lock is acquired or ctx is done.
+func (m *Mutex) Lock(ctx context.Context) error {
+	return m.mu.Lock(ctx)
+}
+
+// TryLock acquires the lock if it is free and reports whether it did.
+func (m *Mutex) TryLock(ctx context.Context) (bool, error) {
+	err := m.mu.TryLock(ctx)
+	if errors.Is(err, concurrency.ErrLocked) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *Mutex) Unlock(ctx context.Context) error {
+	return m.mu.Unlock(ctx)
+}
+
+// Key is the etcd key of this holder, valid while the lock is held.
+func (m *Mutex) Key() string {
+	return m.mu.Key()
+}
+
+// Election elects one leader among the sessions campaigning under a prefix.
+type Election struct {
+	election *concurrency.Election
+}
+
+func (s *Session) NewElection(prefix string) (*Election, error) {
+	cs, err := s.concurrencySession()
+	if err != nil {
+		return nil, err
+	}
+	return &Election{election: concurrency.NewElection(cs, prefix)}, nil
+}
+
+// Campaign blocks until this session is elected with value or ctx is done.
+func (e *Election) Campaign(ctx context.Context, value string) error {
+	return e.election.Campaign(ctx, value)
+}
+
+// Proclaim updates the value announced by the current leader.
+func (e *Election) Proclaim(ctx context.Context, value string) error {
+	return e.election.Proclaim(ctx, value)
+}
+
+// Resign gives up leadership so another campaigner can be elected.
+func (e *Election) Resign(ctx context.Context) error {
+	return e.election.Resign(ctx)
+}
+
+// Leader returns the value of the current leader.
+func (e *Election) Leader(ctx context.Context) (string, error) {
+	res, err := e.election.Leader(ctx)
+	if err != nil {
+		if errors.Is(err, concurrency.ErrElectionNoLeader) {
+			return "", ErrNoLeader
+		}
+		return "", err
+	}
+	return string(res.Kvs[0].Value), nil
+}
+
+// Observe streams the value of every new leader until ctx is done.
+func (e *Election) Observe(ctx context.Context) <-chan string {
+	out := make(chan string)
+
+	go func() {
+		defer close(out)
+		for res := range e.election.Observe(ctx) {
+			if len(res.Kvs) == 0 {
+				continue
+			}
+			select {
+			case out <- string(res.Kvs[0].Value):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// DoubleBarrier holds count participants at Enter until all of them arrived,
+// and at Leave until all of them left.
+type DoubleBarrier struct {
+	barrier *recipe.DoubleBarrier
+}
+
+func (s *Session) NewDoubleBarrier(key string, count int) (*DoubleBarrier, error) {
+	if count <= 0 {
+		return nil, errors.New("barrier count must be positive")
+	}
+
+	cs, err := s.concurrencySession()
+	if err != nil {
+		return nil, err
+	}
+	return &DoubleBarrier{barrier: recipe.NewDoubleBarrier(cs, key, count)}, nil
+}
+
+func (b *DoubleBarrier) Enter() error {
+	return b.barrier.Enter()
+}
+
+func (b *DoubleBarrier) Leave() error {
+	return b.barrier.Leave()
+}
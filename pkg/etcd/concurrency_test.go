@@ -0,0 +1,356 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testEndpoint string
+
+// TestMain runs the tests against an in-process etcd server.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "etcd-test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	server, err := startEmbedded(dir)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+
+	server.Close()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func startEmbedded(dir string) (*embed.Etcd, error) {
+	clientURL, err := freeURL()
+	if err != nil {
+		return nil, err
+	}
+	peerURL, err := freeURL()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	cfg.ListenClientUrls = []url.URL{*clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{*clientURL}
+	cfg.ListenPeerUrls = []url.URL{*peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		server.Close()
+		return nil, errors.New("embedded etcd did not start")
+	}
+
+	testEndpoint = clientURL.String()
+	return server, nil
+}
+
+func freeURL() (*url.URL, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	return url.Parse("http://" + l.Addr().String())
+}
+
+func newTestEtcd(t *testing.T) *Etcd {
+	t.Helper()
+
+	e, err := New(Config{Endpoints: []string{testEndpoint}, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = e.Close() })
+	return e
+}
+
+func newTestSession(t *testing.T, e *Etcd) *Session {
+	t.Helper()
+
+	s, err := e.NewSession(context.Background(), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestMutexContention(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+
+	m1, err := newTestSession(t, e).NewMutex("/test/mutex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := newTestSession(t, e).NewMutex("/test/mutex")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m1.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a held lock is reported as not acquired, not as a failure
+	ok, err := m2.TryLock(ctx)
+	if err != nil || ok {
+		t.Fatalf("TryLock on a held lock = %v, %v, want false, nil", ok, err)
+	}
+
+	var acquired atomic.Bool
+	done := make(chan error)
+	go func() {
+		err := m2.Lock(ctx)
+		acquired.Store(true)
+		done <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	if acquired.Load() {
+		t.Fatal("Lock returned while the lock was held")
+	}
+
+	if err = m1.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock did not return after Unlock")
+	}
+
+	ok, err = m1.TryLock(ctx)
+	if err != nil || ok {
+		t.Fatalf("TryLock on a held lock = %v, %v, want false, nil", ok, err)
+	}
+
+	if err = m2.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ok, err = m1.TryLock(ctx)
+	if err != nil || !ok {
+		t.Fatalf("TryLock on a free lock = %v, %v, want true, nil", ok, err)
+	}
+	if err = m1.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestElectionHandover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	e := newTestEtcd(t)
+
+	e1, err := newTestSession(t, e).NewElection("/test/election")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := newTestSession(t, e).NewElection("/test/election")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = e1.Leader(ctx); !errors.Is(err, ErrNoLeader) {
+		t.Fatalf("Leader without campaign = %v, want ErrNoLeader", err)
+	}
+
+	if err = e1.Campaign(ctx, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	observed := e2.Observe(ctx)
+	if leader := <-observed; leader != "first" {
+		t.Fatalf("observed leader %q, want first", leader)
+	}
+
+	elected := make(chan error)
+	go func() {
+		elected <- e2.Campaign(ctx, "second")
+	}()
+
+	select {
+	case err = <-elected:
+		t.Fatalf("second campaign returned while the first leader holds it: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err = e1.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-elected; err != nil {
+		t.Fatal(err)
+	}
+
+	if leader := <-observed; leader != "second" {
+		t.Fatalf("observed leader %q, want second", leader)
+	}
+	leader, err := e1.Leader(ctx)
+	if err != nil || leader != "second" {
+		t.Fatalf("Leader = %q, %v, want second", leader, err)
+	}
+
+	if err = e2.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDoubleBarrier(t *testing.T) {
+	const count = 3
+	e := newTestEtcd(t)
+
+	barriers := make([]*DoubleBarrier, count)
+	for i := range barriers {
+		b, err := newTestSession(t, e).NewDoubleBarrier("/test/barrier", count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		barriers[i] = b
+	}
+
+	var entered, left atomic.Int32
+	errs := make(chan error, count)
+	var wg sync.WaitGroup
+	for i, b := range barriers {
+		wg.Add(1)
+		go func(i int, b *DoubleBarrier) {
+			defer wg.Done()
+
+			// the last participant arrives late, the others must wait for it
+			if i == count-1 {
+				time.Sleep(200 * time.Millisecond)
+			}
+
+			entered.Add(1)
+			if err := b.Enter(); err != nil {
+				errs <- err
+				return
+			}
+			if n := entered.Load(); n != count {
+				errs <- fmt.Errorf("passed Enter with %d of %d participants", n, count)
+				return
+			}
+
+			if i == count-1 {
+				time.Sleep(200 * time.Millisecond)
+			}
+
+			left.Add(1)
+			if err := b.Leave(); err != nil {
+				errs <- err
+				return
+			}
+			if n := left.Load(); n != count {
+				errs <- fmt.Errorf("passed Leave with %d of %d participants", n, count)
+			}
+		}(i, b)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("barrier participants did not finish")
+	}
+
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestBlockedCancel(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+
+	m1, err := newTestSession(t, e).NewMutex("/test/cancel/mutex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := newTestSession(t, e).NewMutex("/test/cancel/mutex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m1.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer m1.Unlock(ctx)
+
+	lockCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err = m2.Lock(lockCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lock on a held lock = %v, want context.DeadlineExceeded", err)
+	}
+
+	e1, err := newTestSession(t, e).NewElection("/test/cancel/election")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := newTestSession(t, e).NewElection("/test/cancel/election")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e1.Campaign(ctx, "leader"); err != nil {
+		t.Fatal(err)
+	}
+	defer e1.Resign(ctx)
+
+	campaignCtx, cancelCampaign := context.WithCancel(ctx)
+	campaigned := make(chan error)
+	go func() {
+		campaigned <- e2.Campaign(campaignCtx, "follower")
+	}()
+	time.Sleep(200 * time.Millisecond)
+	cancelCampaign()
+
+	select {
+	case err = <-campaigned:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("cancelled Campaign = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Campaign did not return after cancel")
+	}
+
+	leader, err := e2.Leader(ctx)
+	if err != nil || leader != "leader" {
+		t.Fatalf("Leader after cancelled campaign = %q, %v, want leader", leader, err)
+	}
+}
@@ -2,71 +2,194 @@ package etcd
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	client3 "go.etcd.io/etcd/client/v3"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// TIMEOUT is the default per-operation timeout.
 const TIMEOUT = 1000 * time.Millisecond
 
 var lock = &sync.Mutex{}
-var etcdInstance *Etcd
+var etcdInstance atomic.Pointer[Etcd]
+var etcdConfig atomic.Pointer[Config]
 
-type conf struct {
-	Urls     []string
-	UserName string
-	Password string
-}
+type Config struct {
+	Endpoints []string `json:"endpoints"`
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+
+	// CertFile, KeyFile and CAFile enable TLS with client certificates; TLS
+	// takes precedence when set.
+	CertFile           string      `json:"cert_file"`
+	KeyFile            string      `json:"key_file"`
+	CAFile             string      `json:"ca_file"`
+	InsecureSkipVerify bool        `json:"insecure_skip_verify"`
+	TLS                *tls.Config `json:"-"`
 
-var etcdConf = &conf{
-	Urls:     []string{},
-	UserName: "",
-	Password: "",
+	DialTimeout          time.Duration `json:"dial_timeout"`
+	DialKeepAliveTime    time.Duration `json:"dial_keep_alive_time"`
+	DialKeepAliveTimeout time.Duration `json:"dial_keep_alive_timeout"`
+	// AutoSyncInterval refreshes the endpoints from the cluster membership,
+	// 0 disables it.
+	AutoSyncInterval time.Duration `json:"auto_sync_interval"`
+	// Timeout bounds every single operation, TIMEOUT by default.
+	Timeout time.Duration `json:"timeout"`
 }
 
 type Etcd struct {
-	cli *client3.Client
+	cli     *client3.Client
+	timeout time.Duration
 }
 
 type Item struct {
-	Path  string `json:"path"`
-	Value string `json:"value"`
+	Path        string `json:"path"`
+	Value       string `json:"value"`
+	ModRevision int64  `json:"mod_revision"`
+
+	// used by GetList to sort by other targets
+	createRevision int64
+	version        int64
 }
 
-func Service() *Etcd {
-	if etcdInstance != nil {
-		return etcdInstance
+// New connects to the cluster described by conf.
+func New(conf Config) (*Etcd, error) {
+	if len(conf.Endpoints) == 0 {
+		return nil, errors.New("etcd endpoints are empty")
+	}
+
+	tlsConfig, err := conf.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialTimeout := conf.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = TIMEOUT
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = TIMEOUT
+	}
+
+	cli, err := client3.New(client3.Config{
+		Endpoints:            conf.Endpoints,
+		Username:             conf.Username,
+		Password:             conf.Password,
+		TLS:                  tlsConfig,
+		DialTimeout:          dialTimeout,
+		DialKeepAliveTime:    conf.DialKeepAliveTime,
+		DialKeepAliveTimeout: conf.DialKeepAliveTimeout,
+		AutoSyncInterval:     conf.AutoSyncInterval,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Etcd{cli: cli, timeout: timeout}, nil
+}
+
+func (conf Config) tlsConfig() (*tls.Config, error) {
+	if conf.TLS != nil {
+		return conf.TLS, nil
+	}
+	if conf.CertFile == "" && conf.KeyFile == "" && conf.CAFile == "" && !conf.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	info := transport.TLSInfo{
+		CertFile:           conf.CertFile,
+		KeyFile:            conf.KeyFile,
+		TrustedCAFile:      conf.CAFile,
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+	}
+	return info.ClientConfig()
+}
+
+// GetEtcd returns the shared client, connecting on first use.
+func GetEtcd() (*Etcd, error) {
+	if e := etcdInstance.Load(); e != nil {
+		return e, nil
 	}
 
 	lock.Lock()
 	defer lock.Unlock()
 
-	if etcdInstance != nil {
-		return etcdInstance
+	if e := etcdInstance.Load(); e != nil {
+		return e, nil
 	}
 
-	etcdInstance = initEtcd(etcdConf)
+	e, err := New(getEtcdConfig())
+	if err != nil {
+		return nil, err
+	}
+	etcdInstance.Store(e)
 
-	return etcdInstance
+	return e, nil
 }
 
-func initEtcd(etcdConfig *conf) *Etcd {
-	cli, err := client3.New(client3.Config{
-		DialTimeout: TIMEOUT,
-		Endpoints:   etcdConfig.Urls,
-		Username:    etcdConfig.UserName,
-		Password:    etcdConfig.Password,
-	})
+// Service returns the shared client and panics when it cannot be created.
+//
+// Deprecated: use GetEtcd, which returns the error.
+func Service() *Etcd {
+	e, err := GetEtcd()
 	if err != nil {
-		panic("connect etcd failed")
+		panic("connect etcd failed: " + err.Error())
+	}
+	return e
+}
+
+// closeGrace is how long a replaced client stays open for its holders.
+const closeGrace = time.Minute
+
+// SetConfig replaces the configuration of the shared client. The next GetEtcd
+// call connects with conf. The current client is closed after closeGrace, so
+// calls that fetched it just before can finish; long-lived holders like
+// sessions, watches and leases must be rebuilt with GetEtcd within that time.
+func SetConfig(conf Config) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	etcdConfig.Store(&conf)
+	if old := etcdInstance.Swap(nil); old != nil {
+		time.AfterFunc(closeGrace, func() {
+			_ = old.Close()
+		})
 	}
-	return &Etcd{cli: cli}
+}
+
+func getEtcdConfig() Config {
+	if conf := etcdConfig.Load(); conf != nil {
+		return *conf
+	}
+
+	return Config{
+		Endpoints: []string{},
+		Username:  "",
+		Password:  "",
+	}
+}
+
+// Close releases the connections. Sessions, watches and locks created from
+// the client stop working.
+func (e *Etcd) Close() error {
+	return e.cli.Close()
+}
+
+// withTimeout bounds one operation by the client timeout.
+func (e *Etcd) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, e.timeout)
 }
 
 func (e *Etcd) Delete(ctx context.Context, keyPath string) error {
 	kv := client3.KV(e.cli)
-	ctx, _ = context.WithTimeout(ctx, TIMEOUT)
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
 	_, err := kv.Delete(ctx, keyPath)
 	if err != nil {
 		return err
@@ -76,7 +199,9 @@ func (e *Etcd) Delete(ctx context.Context, keyPath string) error {
 
 func (e *Etcd) Put(ctx context.Context, keyPath, value string) error {
 	kv := client3.KV(e.cli)
-	ctx, _ = context.WithTimeout(ctx, TIMEOUT)
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
 	_, err := kv.Put(ctx, keyPath, value)
 	if err != nil {
 		return err
@@ -86,7 +211,9 @@ func (e *Etcd) Put(ctx context.Context, keyPath, value string) error {
 
 func (e *Etcd) Get(ctx context.Context, keyPath string) (string, error) {
 	kv := client3.KV(e.cli)
-	ctx, _ = context.WithTimeout(ctx, TIMEOUT)
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
 	res, err := kv.Get(ctx, keyPath)
 	if err != nil {
 		return "", err
@@ -103,7 +230,9 @@ func (e *Etcd) Get(ctx context.Context, keyPath string) (string, error) {
 
 func (e *Etcd) GetBytes(ctx context.Context, keyPath string) ([]byte, error) {
 	kv := client3.KV(e.cli)
-	ctx, _ = context.WithTimeout(ctx, TIMEOUT)
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
 	res, err := kv.Get(ctx, keyPath)
 	if err != nil {
 		return nil, err
@@ -118,24 +247,29 @@ func (e *Etcd) GetBytes(ctx context.Context, keyPath string) ([]byte, error) {
 	return nil, errors.New("no value in etcd")
 }
 
-func (e *Etcd) GetList(ctx context.Context, keyPath string) ([]*Item, error) {
-	kv := client3.KV(e.cli)
-	ctx, _ = context.WithTimeout(ctx, TIMEOUT)
-	res, err := kv.Get(ctx, keyPath, client3.WithPrefix())
-	if err != nil {
-		return nil, err
-	}
-
+// GetList reads every key under keyPath. Large prefixes are read in pages at
+// one revision, see Iterate for the options.
+func (e *Etcd) GetList(ctx context.Context, keyPath string, opts ...RangeOption) ([]*Item, error) {
 	var items []*Item
 
-	for _, val := range res.Kvs {
-		item := new(Item)
-		item.Path = string(val.Key[:])
-		item.Value = string(val.Value[:])
+	// other orders cannot be paginated, the keys are read in key order and
+	// sorted here
+	o := newRangeOptions(e.timeout, opts)
+	if o.target != client3.SortByKey {
+		opts = append(opts, WithSort(client3.SortByKey, client3.SortAscend))
+	}
 
-		items = append(items, item)
+	it := e.Iterate(keyPath, opts...)
+	for it.Next(ctx) {
+		items = append(items, it.Items()...)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
 	}
 
+	if o.target != client3.SortByKey {
+		sortItems(items, o.target, o.order)
+	}
 	return items, nil
 }
 
@@ -0,0 +1,185 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	client3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PutWithTTL writes an ephemeral key that is removed once ttl passes. It
+// grants a dedicated lease and returns it so the caller can refresh it.
+func (e *Etcd) PutWithTTL(ctx context.Context, keyPath, value string, ttl time.Duration) (client3.LeaseID, error) {
+	ctx, cancel := e.withTimeout(ctx)
+	defer cancel()
+
+	lease, err := e.cli.Grant(ctx, ttlSeconds(ttl))
+	if err != nil {
+		return client3.NoLease, err
+	}
+
+	if _, err = e.cli.Put(ctx, keyPath, value, client3.WithLease(lease.ID)); err != nil {
+		return client3.NoLease, err
+	}
+
+	return lease.ID, nil
+}
+
+// Session is a lease kept alive in the background. Keys written through it
+// disappear when the process dies or the session is closed, which makes it the
+// building block for service registration and heartbeats.
+type Session struct {
+	etcd   *Etcd
+	id     client3.LeaseID
+	cancel context.CancelFunc
+
+	lost      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// expiry is when the lease expires unless kept alive, in unix nanoseconds
+	expiry atomic.Int64
+
+	mu     sync.Mutex
+	closed bool
+	cs     *concurrency.Session
+}
+
+// NewSession grants a lease with ttl and keeps it alive until Close.
+func (e *Etcd) NewSession(ctx context.Context, ttl time.Duration) (*Session, error) {
+	start := time.Now()
+	grantCtx, cancel := e.withTimeout(ctx)
+	lease, err := e.cli.Grant(grantCtx, ttlSeconds(ttl))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	keepCtx, keepCancel := context.WithCancel(context.Background())
+	ch, err := e.cli.KeepAlive(keepCtx, lease.ID)
+	if err != nil {
+		keepCancel()
+		return nil, err
+	}
+
+	s := &Session{
+		etcd:   e,
+		id:     lease.ID,
+		cancel: keepCancel,
+		lost:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	s.expiry.Store(start.Add(time.Duration(lease.TTL) * time.Second).UnixNano())
+
+	go s.keepAlive(ch)
+
+	return s, nil
+}
+
+func (s *Session) keepAlive(ch <-chan *client3.LeaseKeepAliveResponse) {
+	defer close(s.done)
+
+	for res := range ch {
+		s.expiry.Store(time.Now().Add(time.Duration(res.TTL) * time.Second).UnixNano())
+	}
+
+	// the keepalive channel closes on Close or when the lease could not be
+	// renewed before it expired
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		close(s.lost)
+	}
+}
+
+func (s *Session) Lease() client3.LeaseID {
+	return s.id
+}
+
+// Lost is closed when the lease expired or was revoked by someone else. Keys
+// of the session are gone at that point and must be written again with a new
+// session.
+func (s *Session) Lost() <-chan struct{} {
+	return s.lost
+}
+
+// Expiry is when the lease expires unless it is kept alive again, as of the
+// last keepalive response. It is measured on receipt, so the lease may
+// expire up to a network round trip earlier; Lost only closes after the
+// expiry passed.
+func (s *Session) Expiry() time.Time {
+	return time.Unix(0, s.expiry.Load())
+}
+
+// Put writes keyPath attached to the session lease.
+func (s *Session) Put(ctx context.Context, keyPath, value string) error {
+	select {
+	case <-s.lost:
+		return errors.New("etcd session lease lost")
+	default:
+	}
+
+	ctx, cancel := s.etcd.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.etcd.cli.Put(ctx, keyPath, value, client3.WithLease(s.id))
+	return err
+}
+
+// PutIfAbsent writes keyPath attached to the session lease only when it does
+// not exist yet, and reports whether it did.
+func (s *Session) PutIfAbsent(ctx context.Context, keyPath, value string) (bool, error) {
+	select {
+	case <-s.lost:
+		return false, errors.New("etcd session lease lost")
+	default:
+	}
+
+	ctx, cancel := s.etcd.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.etcd.cli.Txn(ctx).
+		If(client3.Compare(client3.CreateRevision(keyPath), "=", 0)).
+		Then(client3.OpPut(keyPath, value, client3.WithLease(s.id))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return res.Succeeded, nil
+}
+
+// Close stops the keepalive and revokes the lease, removing every key
+// attached to it.
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		if s.cs != nil {
+			// the lease is revoked below, the concurrency session only has to
+			// stop its own keepalive
+			s.cs.Orphan()
+		}
+		s.mu.Unlock()
+
+		s.cancel()
+		<-s.done
+
+		ctx, cancel := s.etcd.withTimeout(context.Background())
+		defer cancel()
+		_, err = s.etcd.cli.Revoke(ctx, s.id)
+	})
+	return err
+}
+
+// ttlSeconds rounds ttl up to whole seconds, the lease granularity of etcd.
+func ttlSeconds(ttl time.Duration) int64 {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
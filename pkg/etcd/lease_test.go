@@ -0,0 +1,29 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSessionExpiry(t *testing.T) {
+	e := newTestEtcd(t)
+
+	start := time.Now()
+	s, err := e.NewSession(context.Background(), 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if expiry := s.Expiry(); expiry.Before(start.Add(2*time.Second)) || expiry.After(time.Now().Add(4*time.Second)) {
+		t.Fatalf("Expiry after grant = %s from now, want about 3s", time.Until(expiry))
+	}
+
+	// the keepalive runs every third of the ttl and pushes the expiry out
+	first := s.Expiry()
+	time.Sleep(2 * time.Second)
+	if !s.Expiry().After(first) {
+		t.Fatal("Expiry was not extended by the keepalive")
+	}
+}
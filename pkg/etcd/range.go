@@ -0,0 +1,249 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	client3 "go.etcd.io/etcd/client/v3"
+	"sort"
+	"time"
+)
+
+const defaultPageSize = 1000
+
+// ErrUnsortedPages is returned by an Iterator sorted by something else than
+// the key when the range spans more than one page.
+var ErrUnsortedPages = errors.New("etcd range sorted by a non-key target spans more than one page")
+
+type rangeOptions struct {
+	pageSize int64
+	target   client3.SortTarget
+	order    client3.SortOrder
+	keysOnly bool
+	revision int64
+	timeout  time.Duration
+}
+
+type RangeOption func(*rangeOptions)
+
+// WithPageSize sets how many keys one request reads.
+func WithPageSize(n int64) RangeOption {
+	return func(o *rangeOptions) {
+		o.pageSize = n
+	}
+}
+
+// WithSort orders the result. Only key order can be paginated: an Iterator
+// with any other target fails with ErrUnsortedPages when the range does not
+// fit in one page, GetList reads it in key order and sorts it itself.
+func WithSort(target client3.SortTarget, order client3.SortOrder) RangeOption {
+	return func(o *rangeOptions) {
+		o.target = target
+		o.order = order
+	}
+}
+
+// WithKeysOnly skips the values.
+func WithKeysOnly() RangeOption {
+	return func(o *rangeOptions) {
+		o.keysOnly = true
+	}
+}
+
+// WithRevision reads the range as it was at revision. Without it the
+// revision of the first page is used for the following ones, so a
+// multi-page read is still a consistent snapshot.
+func WithRevision(revision int64) RangeOption {
+	return func(o *rangeOptions) {
+		o.revision = revision
+	}
+}
+
+// WithTimeout bounds each request, the client timeout by default.
+func WithTimeout(timeout time.Duration) RangeOption {
+	return func(o *rangeOptions) {
+		o.timeout = timeout
+	}
+}
+
+func newRangeOptions(timeout time.Duration, opts []RangeOption) *rangeOptions {
+	o := &rangeOptions{
+		pageSize: defaultPageSize,
+		target:   client3.SortByKey,
+		order:    client3.SortAscend,
+		timeout:  timeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.pageSize <= 0 {
+		o.pageSize = defaultPageSize
+	}
+	if o.order == client3.SortNone {
+		o.order = client3.SortAscend
+	}
+	return o
+}
+
+// Iterator reads the keys under a prefix page by page:
+//
+//	it := e.Iterate(prefix, etcd.WithPageSize(100))
+//	for it.Next(ctx) {
+//		for _, item := range it.Items() { ... }
+//	}
+//	if err := it.Err(); err != nil { ... }
+type Iterator struct {
+	etcd   *Etcd
+	prefix string
+	opts   *rangeOptions
+
+	// [from, to) is the part of the range not read yet
+	from string
+	to   string
+
+	revision int64
+	items    []*Item
+	err      error
+	done     bool
+}
+
+// Iterate returns an iterator over the keys under prefix, every key when it
+// is "".
+func (e *Etcd) Iterate(prefix string, opts ...RangeOption) *Iterator {
+	o := newRangeOptions(e.timeout, opts)
+
+	// etcd rejects the empty key, like clientv3.WithPrefix the whole key
+	// space starts at "\x00"
+	from := prefix
+	if len(from) == 0 {
+		from = "\x00"
+	}
+
+	return &Iterator{
+		etcd:     e,
+		prefix:   prefix,
+		opts:     o,
+		from:     from,
+		to:       client3.GetPrefixRangeEnd(prefix),
+		revision: o.revision,
+	}
+}
+
+// Next reads the next page and reports whether it holds any keys.
+func (it *Iterator) Next(ctx context.Context) bool {
+	if it.done || it.err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, it.opts.timeout)
+	defer cancel()
+
+	ops := []client3.OpOption{
+		client3.WithRange(it.to),
+		client3.WithLimit(it.opts.pageSize),
+		client3.WithSort(it.opts.target, it.opts.order),
+	}
+	if it.revision > 0 {
+		ops = append(ops, client3.WithRev(it.revision))
+	}
+	if it.opts.keysOnly {
+		ops = append(ops, client3.WithKeysOnly())
+	}
+
+	res, err := it.etcd.cli.Get(ctx, it.from, ops...)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	if it.revision == 0 {
+		it.revision = res.Header.Revision
+	}
+
+	if res.More && it.opts.target != client3.SortByKey {
+		it.err = ErrUnsortedPages
+		return false
+	}
+
+	it.items = it.items[:0]
+	for _, kv := range res.Kvs {
+		it.items = append(it.items, &Item{
+			Path:           string(kv.Key),
+			Value:          string(kv.Value),
+			ModRevision:    kv.ModRevision,
+			createRevision: kv.CreateRevision,
+			version:        kv.Version,
+		})
+	}
+
+	if !res.More || len(res.Kvs) == 0 {
+		it.done = true
+	} else {
+		last := string(res.Kvs[len(res.Kvs)-1].Key)
+		if it.opts.order == client3.SortDescend {
+			it.to = last
+		} else {
+			it.from = last + "\x00"
+		}
+	}
+
+	return len(it.items) > 0
+}
+
+// Items returns the keys of the current page. The slice is reused by Next.
+func (it *Iterator) Items() []*Item {
+	return it.items
+}
+
+// Revision is the store revision the iterator reads at.
+func (it *Iterator) Revision() int64 {
+	return it.revision
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// sortItems orders items by a non-key target.
+func sortItems(items []*Item, target client3.SortTarget, order client3.SortOrder) {
+	less := func(a, b *Item) bool {
+		switch target {
+		case client3.SortByVersion:
+			return a.version < b.version
+		case client3.SortByCreateRevision:
+			return a.createRevision < b.createRevision
+		case client3.SortByModRevision:
+			return a.ModRevision < b.ModRevision
+		case client3.SortByValue:
+			return a.Value < b.Value
+		default:
+			return a.Path < b.Path
+		}
+	}
+
+	// items come in key order, which stays the order of equal ones like on
+	// the server
+	sort.SliceStable(items, func(i, j int) bool {
+		if order == client3.SortDescend {
+			return less(items[j], items[i])
+		}
+		return less(items[i], items[j])
+	})
+}
+
+// Count returns the number of keys under prefix without reading them.
+func (e *Etcd) Count(ctx context.Context, prefix string, opts ...RangeOption) (int64, error) {
+	o := newRangeOptions(e.timeout, opts)
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	ops := []client3.OpOption{client3.WithPrefix(), client3.WithCountOnly()}
+	if o.revision > 0 {
+		ops = append(ops, client3.WithRev(o.revision))
+	}
+
+	res, err := e.cli.Get(ctx, prefix, ops...)
+	if err != nil {
+		return 0, err
+	}
+	return res.Count, nil
+}
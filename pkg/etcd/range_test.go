@@ -0,0 +1,66 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	client3 "go.etcd.io/etcd/client/v3"
+	"testing"
+)
+
+func TestGetListSortedPages(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+
+	values := map[string]string{"a": "3", "b": "5", "c": "1", "d": "4", "e": "2"}
+	for key, value := range values {
+		if err := e.Put(ctx, "/test/range/"+key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := e.GetList(ctx, "/test/range/", WithPageSize(2), WithSort(client3.SortByValue, client3.SortDescend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	for _, item := range items {
+		got += item.Value
+	}
+	if got != "54321" {
+		t.Fatalf("GetList by value = %q, want 54321", got)
+	}
+
+	items, err = e.GetList(ctx, "/test/range/", WithPageSize(2))
+	if err != nil || len(items) != len(values) || items[0].Path != "/test/range/a" {
+		t.Fatalf("GetList by key = %d items, %v", len(items), err)
+	}
+
+	it := e.Iterate("/test/range/", WithPageSize(2), WithSort(client3.SortByModRevision, client3.SortAscend))
+	for it.Next(ctx) {
+	}
+	if !errors.Is(it.Err(), ErrUnsortedPages) {
+		t.Fatalf("Iterator by revision over pages = %v, want ErrUnsortedPages", it.Err())
+	}
+}
+
+func TestGetListAllKeys(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+
+	if err := e.Put(ctx, "/test/all/a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := e.GetList(ctx, "", WithPageSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, item := range items {
+		found = found || item.Path == "/test/all/a"
+	}
+	if !found {
+		t.Fatalf("GetList of every key = %d items without /test/all/a", len(items))
+	}
+}
@@ -0,0 +1,161 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	client3 "go.etcd.io/etcd/client/v3"
+)
+
+// TxnResult tells whether the conditions of a transaction held, which branch
+// ran, and the store revision after it. Items holds the keys read by Get
+// operations of the branch that ran.
+type TxnResult struct {
+	Succeeded bool    `json:"succeeded"`
+	Revision  int64   `json:"revision"`
+	Items     []*Item `json:"items"`
+}
+
+// Txn builds an etcd transaction: when every If condition holds the Then
+// operations run, otherwise the Else operations.
+type Txn struct {
+	etcd    *Etcd
+	err     error
+	cmps    []client3.Cmp
+	thenOps []client3.Op
+	elseOps []client3.Op
+}
+
+func (e *Etcd) Txn() *Txn {
+	return &Txn{etcd: e}
+}
+
+// IfValue compares the value of keyPath, op is one of "=", "!=", "<", ">";
+// any other operator makes Commit fail.
+func (t *Txn) IfValue(keyPath, op, value string) *Txn {
+	return t.compare(keyPath, op, func() client3.Cmp {
+		return client3.Compare(client3.Value(keyPath), op, value)
+	})
+}
+
+// compare adds the comparison built by cmp, client3.Compare panics on an
+// unknown operator so it is checked first and reported by Commit.
+func (t *Txn) compare(keyPath, op string, cmp func() client3.Cmp) *Txn {
+	switch op {
+	case "=", "!=", "<", ">":
+		t.cmps = append(t.cmps, cmp())
+	default:
+		if t.err == nil {
+			t.err = fmt.Errorf("etcd txn: unknown compare operator %q for %s", op, keyPath)
+		}
+	}
+	return t
+}
+
+// IfModRevision compares the revision of the last write to keyPath.
+func (t *Txn) IfModRevision(keyPath, op string, revision int64) *Txn {
+	return t.compare(keyPath, op, func() client3.Cmp {
+		return client3.Compare(client3.ModRevision(keyPath), op, revision)
+	})
+}
+
+// IfCreateRevision compares the revision keyPath was created at, 0 when it
+// does not exist.
+func (t *Txn) IfCreateRevision(keyPath, op string, revision int64) *Txn {
+	return t.compare(keyPath, op, func() client3.Cmp {
+		return client3.Compare(client3.CreateRevision(keyPath), op, revision)
+	})
+}
+
+// IfVersion compares the number of writes to keyPath since it was created.
+func (t *Txn) IfVersion(keyPath, op string, version int64) *Txn {
+	return t.compare(keyPath, op, func() client3.Cmp {
+		return client3.Compare(client3.Version(keyPath), op, version)
+	})
+}
+
+func (t *Txn) ThenPut(keyPath, value string) *Txn {
+	t.thenOps = append(t.thenOps, client3.OpPut(keyPath, value))
+	return t
+}
+
+func (t *Txn) ThenDelete(keyPath string) *Txn {
+	t.thenOps = append(t.thenOps, client3.OpDelete(keyPath))
+	return t
+}
+
+func (t *Txn) ThenGet(keyPath string) *Txn {
+	t.thenOps = append(t.thenOps, client3.OpGet(keyPath))
+	return t
+}
+
+func (t *Txn) ElsePut(keyPath, value string) *Txn {
+	t.elseOps = append(t.elseOps, client3.OpPut(keyPath, value))
+	return t
+}
+
+func (t *Txn) ElseDelete(keyPath string) *Txn {
+	t.elseOps = append(t.elseOps, client3.OpDelete(keyPath))
+	return t
+}
+
+func (t *Txn) ElseGet(keyPath string) *Txn {
+	t.elseOps = append(t.elseOps, client3.OpGet(keyPath))
+	return t
+}
+
+func (t *Txn) Commit(ctx context.Context) (*TxnResult, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	ctx, cancel := t.etcd.withTimeout(ctx)
+	defer cancel()
+
+	res, err := t.etcd.cli.Txn(ctx).If(t.cmps...).Then(t.thenOps...).Else(t.elseOps...).Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TxnResult{Succeeded: res.Succeeded, Revision: res.Header.Revision}
+	for _, op := range res.Responses {
+		rangeRes := op.GetResponseRange()
+		if rangeRes == nil {
+			continue
+		}
+		for _, kv := range rangeRes.Kvs {
+			result.Items = append(result.Items, &Item{Path: string(kv.Key), Value: string(kv.Value), ModRevision: kv.ModRevision})
+		}
+	}
+
+	return result, nil
+}
+
+// PutIfAbsent writes keyPath only when it does not exist yet.
+func (e *Etcd) PutIfAbsent(ctx context.Context, keyPath, value string) (*TxnResult, error) {
+	return e.Txn().IfCreateRevision(keyPath, "=", 0).ThenPut(keyPath, value).Commit(ctx)
+}
+
+// GetItem reads keyPath together with its ModRevision, to be passed to
+// PutIfRevision.
+func (e *Etcd) GetItem(ctx context.Context, keyPath string) (*Item, error) {
+	res, err := e.Txn().ThenGet(keyPath).Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Items) == 0 {
+		return nil, errors.New("no value in etcd")
+	}
+	return res.Items[0], nil
+}
+
+// PutIfRevision writes keyPath only when its last write happened at revision,
+// the usual read-modify-write guard against concurrent writers.
+func (e *Etcd) PutIfRevision(ctx context.Context, keyPath, value string, revision int64) (*TxnResult, error) {
+	return e.Txn().IfModRevision(keyPath, "=", revision).ThenPut(keyPath, value).Commit(ctx)
+}
+
+// DeleteIfValue deletes keyPath only when it still holds value.
+func (e *Etcd) DeleteIfValue(ctx context.Context, keyPath, value string) (*TxnResult, error) {
+	return e.Txn().IfValue(keyPath, "=", value).ThenDelete(keyPath).Commit(ctx)
+}
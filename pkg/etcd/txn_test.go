@@ -0,0 +1,24 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTxnUnknownOperator(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEtcd(t)
+	if err := e.Put(ctx, "/test/txn", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := e.Txn().IfValue("/test/txn", "==", "a").ThenPut("/test/txn", "b").Commit(ctx)
+	if err == nil {
+		t.Fatal("Commit with an unknown operator succeeded")
+	}
+
+	res, err := e.Txn().IfValue("/test/txn", "=", "a").ThenPut("/test/txn", "b").Commit(ctx)
+	if err != nil || !res.Succeeded {
+		t.Fatalf("Commit = %+v, %v, want succeeded", res, err)
+	}
+}
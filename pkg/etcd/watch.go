@@ -0,0 +1,229 @@
+package etcd
+
+import (
+	"context"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	client3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventPut EventType = iota
+	EventDelete
+)
+
+func (t EventType) String() string {
+	if t == EventDelete {
+		return "delete"
+	}
+	return "put"
+}
+
+type Event struct {
+	Type     EventType `json:"type"`
+	Key      string    `json:"key"`
+	Value    string    `json:"value"`
+	Revision int64     `json:"revision"`
+}
+
+// Watch streams the changes of keyPath made after the call. The channel is
+// closed when ctx is cancelled.
+func (e *Etcd) Watch(ctx context.Context, keyPath string) <-chan *Event {
+	return e.watch(ctx, keyPath, false)
+}
+
+// WatchPrefix streams the changes of every key under prefix made after the
+// call. The channel is closed when ctx is cancelled.
+func (e *Etcd) WatchPrefix(ctx context.Context, prefix string) <-chan *Event {
+	return e.watch(ctx, prefix, true)
+}
+
+// WatchPrefixFrom streams the changes under prefix made after revision,
+// typically the Revision of an Iterator that read the current state into
+// items. Nothing is lost between the read and the watch, and items let a
+// compaction be recovered from like with WatchPrefix.
+func (e *Etcd) WatchPrefixFrom(ctx context.Context, prefix string, revision int64, items []*Item) <-chan *Event {
+	w := e.newWatcher(prefix, true)
+	w.revision = revision
+	for _, item := range items {
+		w.known[item.Path] = item.ModRevision
+	}
+
+	go w.run(ctx)
+
+	return w.out
+}
+
+// watcher keeps a watch alive across reconnects. It resumes from the last
+// revision it has seen, and when that revision was compacted away it reads a
+// snapshot and emits the difference to what it knew as events, so consumers
+// never miss the latest state of a key.
+type watcher struct {
+	etcd   *Etcd
+	key    string
+	prefix bool
+	out    chan *Event
+
+	revision int64
+	known    map[string]int64
+}
+
+func (e *Etcd) watch(ctx context.Context, keyPath string, prefix bool) <-chan *Event {
+	w := e.newWatcher(keyPath, prefix)
+
+	go w.run(ctx)
+
+	return w.out
+}
+
+func (e *Etcd) newWatcher(keyPath string, prefix bool) *watcher {
+	return &watcher{
+		etcd:   e,
+		key:    keyPath,
+		prefix: prefix,
+		out:    make(chan *Event),
+		known:  make(map[string]int64),
+	}
+}
+
+func (w *watcher) run(ctx context.Context) {
+	defer close(w.out)
+
+	// WatchPrefixFrom starts at a known revision
+	for w.revision == 0 && !w.snapshot(ctx, false) {
+		if !sleepContext(ctx, time.Second) {
+			return
+		}
+	}
+
+	for {
+		opts := []client3.OpOption{client3.WithRev(w.revision + 1)}
+		if w.prefix {
+			opts = append(opts, client3.WithPrefix())
+		}
+
+		watchCtx, cancel := context.WithCancel(client3.WithRequireLeader(ctx))
+		compacted := w.consume(ctx, w.etcd.cli.Watch(watchCtx, w.key, opts...))
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !sleepContext(ctx, time.Second) {
+			return
+		}
+
+		if compacted {
+			for !w.snapshot(ctx, true) {
+				if !sleepContext(ctx, time.Second) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// consume forwards the events of one watch and reports whether it ended
+// because the next revision was compacted.
+func (w *watcher) consume(ctx context.Context, wch client3.WatchChan) bool {
+	for resp := range wch {
+		if resp.CompactRevision != 0 {
+			log.Warn("etcd watch revision compacted", zap.String("key", w.key), zap.Int64("revision", resp.CompactRevision))
+			return true
+		}
+		if err := resp.Err(); err != nil {
+			log.Warn("etcd watch failed", zap.String("key", w.key), zap.Error(err))
+			return false
+		}
+
+		for _, ev := range resp.Events {
+			event := &Event{
+				Key:      string(ev.Kv.Key),
+				Value:    string(ev.Kv.Value),
+				Revision: ev.Kv.ModRevision,
+			}
+			if ev.Type == client3.EventTypeDelete {
+				event.Type = EventDelete
+				delete(w.known, event.Key)
+			} else {
+				w.known[event.Key] = event.Revision
+			}
+
+			if !w.emit(ctx, event) {
+				return false
+			}
+			w.revision = event.Revision
+		}
+	}
+	return false
+}
+
+// snapshot reads the current state. With emit set, keys changed or removed
+// since the last seen revision are reported as events.
+func (w *watcher) snapshot(ctx context.Context, emit bool) bool {
+	opts := []client3.OpOption{}
+	if w.prefix {
+		opts = append(opts, client3.WithPrefix())
+	}
+
+	getCtx, cancel := w.etcd.withTimeout(ctx)
+	defer cancel()
+
+	res, err := w.etcd.cli.Get(getCtx, w.key, opts...)
+	if err != nil {
+		log.Warn("etcd watch snapshot failed", zap.String("key", w.key), zap.Error(err))
+		return false
+	}
+
+	current := make(map[string]int64, len(res.Kvs))
+	for _, kv := range res.Kvs {
+		key := string(kv.Key)
+		current[key] = kv.ModRevision
+
+		if emit && w.known[key] != kv.ModRevision {
+			if !w.emit(ctx, &Event{Type: EventPut, Key: key, Value: string(kv.Value), Revision: kv.ModRevision}) {
+				return false
+			}
+		}
+	}
+
+	if emit {
+		for key := range w.known {
+			if _, ok := current[key]; ok {
+				continue
+			}
+			if !w.emit(ctx, &Event{Type: EventDelete, Key: key, Revision: res.Header.Revision}) {
+				return false
+			}
+		}
+	}
+
+	w.known = current
+	w.revision = res.Header.Revision
+	return true
+}
+
+func (w *watcher) emit(ctx context.Context, event *Event) bool {
+	select {
+	case w.out <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
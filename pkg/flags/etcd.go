@@ -0,0 +1,95 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/garfieldlw/common-golang/pkg/etcd"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// Watch loads the flags stored as JSON under prefix, one key per flag named
+// prefix/<flag>, and keeps the store updated until ctx is cancelled. Invalid
+// definitions are logged and skipped.
+func Watch(ctx context.Context, e *etcd.Etcd, prefix string) (*Store, error) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+
+	var items []*etcd.Item
+	it := e.Iterate(prefix)
+	for it.Next(ctx) {
+		items = append(items, it.Items()...)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]*Flag, len(items))
+	for _, item := range items {
+		name := strings.TrimPrefix(item.Path, prefix)
+		if f := decodeFlag(name, item.Value); f != nil {
+			flags[name] = f
+		}
+	}
+	s := newStore(flags)
+
+	go s.watch(e.WatchPrefixFrom(ctx, prefix, it.Revision(), items), prefix)
+
+	return s, nil
+}
+
+func (s *Store) watch(events <-chan *etcd.Event, prefix string) {
+	for event := range events {
+		name := strings.TrimPrefix(event.Key, prefix)
+
+		current := *s.flags.Load()
+		flags := make(map[string]*Flag, len(current)+1)
+		for k, v := range current {
+			flags[k] = v
+		}
+
+		if event.Type == etcd.EventDelete {
+			delete(flags, name)
+		} else {
+			f := decodeFlag(name, event.Value)
+			if f == nil {
+				continue
+			}
+			flags[name] = f
+		}
+
+		s.flags.Store(&flags)
+	}
+}
+
+func decodeFlag(name, value string) *Flag {
+	f := &Flag{}
+	if err := json.Unmarshal([]byte(value), f); err != nil {
+		log.Warn("invalid feature flag", zap.String("flag", name), zap.Error(err))
+		return nil
+	}
+
+	f.Name = name
+	if err := f.compile(); err != nil {
+		log.Warn("invalid feature flag", zap.String("flag", name), zap.Error(err))
+		return nil
+	}
+	return f
+}
+
+// Put stores the definition of f under prefix.
+func Put(ctx context.Context, e *etcd.Etcd, prefix string, f *Flag) error {
+	if err := f.compile(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return e.Put(ctx, strings.TrimSuffix(prefix, "/")+"/"+f.Name, string(data))
+}
+
+func Delete(ctx context.Context, e *etcd.Etcd, prefix, name string) error {
+	return e.Delete(ctx, strings.TrimSuffix(prefix, "/")+"/"+name)
+}
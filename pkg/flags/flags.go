@@ -0,0 +1,183 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	ReasonMissing  = "missing"
+	ReasonDisabled = "disabled"
+	ReasonAllowed  = "allowed"
+	ReasonRollout  = "rollout"
+	ReasonExcluded = "excluded"
+)
+
+// buckets is the rollout resolution, 0.01 percent
+const buckets = 10000
+
+// Flag is a feature toggle. A disabled flag is off for everybody; an enabled
+// one is on for subjects of Allow and for Rollout percent of the others.
+// Variants split the subjects a flag is on for by weight.
+type Flag struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Rollout is the percentage of subjects the flag is on for, all of them
+	// when nil.
+	Rollout  *float64  `json:"rollout,omitempty"`
+	Allow    []string  `json:"allow,omitempty"`
+	Variants []Variant `json:"variants,omitempty"`
+
+	allow map[string]struct{}
+	total int
+}
+
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// Evaluation is the outcome of a flag for one subject.
+type Evaluation struct {
+	Flag    string `json:"flag"`
+	Enabled bool   `json:"enabled"`
+	Variant string `json:"variant"`
+	Reason  string `json:"reason"`
+}
+
+// Store holds a snapshot of the flags. Evaluations read the snapshot without
+// locking; updates replace it as a whole.
+type Store struct {
+	flags atomic.Pointer[map[string]*Flag]
+}
+
+func newStore(flags map[string]*Flag) *Store {
+	s := &Store{}
+	s.flags.Store(&flags)
+	return s
+}
+
+// LoadFile reads the flags from a JSON file holding a list of Flag, for tests
+// and local runs without etcd.
+func LoadFile(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Flag
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]*Flag, len(list))
+	for _, f := range list {
+		if err = f.compile(); err != nil {
+			return nil, err
+		}
+		flags[f.Name] = f
+	}
+
+	return newStore(flags), nil
+}
+
+// Evaluate tells whether flag is on for subject, usually a user id, and which
+// variant it gets. The same subject always gets the same answer while the
+// flag is unchanged.
+func (s *Store) Evaluate(ctx context.Context, flag, subject string) *Evaluation {
+	f, ok := (*s.flags.Load())[flag]
+	if !ok {
+		return &Evaluation{Flag: flag, Reason: ReasonMissing}
+	}
+	return f.evaluate(subject)
+}
+
+func (s *Store) Enabled(ctx context.Context, flag, subject string) bool {
+	return s.Evaluate(ctx, flag, subject).Enabled
+}
+
+// Flags returns the current definitions.
+func (s *Store) Flags() []*Flag {
+	flags := *s.flags.Load()
+	list := make([]*Flag, 0, len(flags))
+	for _, f := range flags {
+		list = append(list, f)
+	}
+	return list
+}
+
+func (f *Flag) compile() error {
+	if len(f.Name) == 0 {
+		return errors.New("flag name is empty")
+	}
+	if f.Rollout != nil && (*f.Rollout < 0 || *f.Rollout > 100) {
+		return errors.New("flag rollout must be within [0, 100]")
+	}
+
+	f.allow = make(map[string]struct{}, len(f.Allow))
+	for _, id := range f.Allow {
+		f.allow[id] = struct{}{}
+	}
+
+	f.total = 0
+	for _, v := range f.Variants {
+		if v.Weight < 0 {
+			return errors.New("flag variant weight must not be negative")
+		}
+		f.total += v.Weight
+	}
+
+	return nil
+}
+
+func (f *Flag) evaluate(subject string) *Evaluation {
+	e := &Evaluation{Flag: f.Name}
+
+	switch {
+	case !f.Enabled:
+		e.Reason = ReasonDisabled
+		return e
+	case f.allowed(subject):
+		e.Reason = ReasonAllowed
+	case f.Rollout != nil && bucket(f.Name, "rollout", subject) >= int(*f.Rollout*buckets/100):
+		e.Reason = ReasonExcluded
+		return e
+	default:
+		e.Reason = ReasonRollout
+	}
+
+	e.Enabled = true
+	e.Variant = f.variant(subject)
+	return e
+}
+
+func (f *Flag) allowed(subject string) bool {
+	_, ok := f.allow[subject]
+	return ok
+}
+
+func (f *Flag) variant(subject string) string {
+	if f.total == 0 {
+		return ""
+	}
+
+	// a separate hash, so the variant does not depend on the rollout bucket
+	n := bucket(f.Name, "variant", subject) % f.total
+	for _, v := range f.Variants {
+		if n < v.Weight {
+			return v.Name
+		}
+		n -= v.Weight
+	}
+	return ""
+}
+
+func bucket(flag, salt, subject string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(flag + "/" + salt + "/" + subject))
+	return int(h.Sum32() % buckets)
+}
@@ -0,0 +1,78 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Command runs a migration command given as arguments, e.g. from os.Args:
+//
+//	up [version]   apply the pending migrations
+//	down [steps]   roll back the last steps migrations, one by default
+//	status         list the migrations and whether they were applied
+//	dry-run        list what up would apply
+func (m *Migrator) Command(ctx context.Context, w io.Writer, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command, one of up, down, status, dry-run")
+	}
+
+	var n int64
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return fmt.Errorf("invalid argument %q", args[1])
+		}
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := m.Up(ctx, n)
+		printMigrations(w, "applied", done)
+		return err
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		done, err := m.Down(ctx, int(n))
+		printMigrations(w, "rolled back", done)
+		return err
+	case "dry-run":
+		dry := *m
+		dry.conf.DryRun = true
+		done, err := dry.Up(ctx, n)
+		printMigrations(w, "would apply", done)
+		return err
+	case "status":
+		list, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range list {
+			at := "pending"
+			if s.AppliedAt != nil {
+				at = s.AppliedAt.Format(time.RFC3339)
+			}
+			_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, at)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func printMigrations(w io.Writer, action string, list []*Migration) {
+	if len(list) == 0 {
+		_, _ = fmt.Fprintln(w, "nothing to do")
+		return
+	}
+	for _, mig := range list {
+		_, _ = fmt.Fprintf(w, "%s %d %s\n", action, mig.Version, mig.Name)
+	}
+}
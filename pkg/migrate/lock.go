@@ -0,0 +1,158 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"hash/fnv"
+	"time"
+)
+
+// lock makes sure only one instance migrates. MySQL and Postgres use an
+// advisory lock held on a dedicated connection; other databases, sqlite in
+// particular, a row in a lock table.
+func (m *Migrator) lock(ctx context.Context, conn *gorm.DB) (func(), error) {
+	switch conn.Dialector.Name() {
+	case "mysql":
+		return m.advisoryLock(ctx, conn,
+			"SELECT GET_LOCK(?, ?)", "SELECT RELEASE_LOCK(?)", m.conf.Table, int64(m.conf.LockTimeout/time.Second))
+	case "postgres":
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(m.conf.Table))
+		key := int64(h.Sum64())
+		return m.advisoryLock(ctx, conn,
+			"SELECT 1 FROM pg_advisory_lock($1)", "SELECT pg_advisory_unlock($1)", key)
+	default:
+		return m.tableLock(ctx, conn)
+	}
+}
+
+func (m *Migrator) advisoryLock(ctx context.Context, conn *gorm.DB, lockSQL, unlockSQL string, args ...any) (func(), error) {
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	dedicated, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, m.conf.LockTimeout)
+	defer cancel()
+
+	// both return 1 once locked; GET_LOCK returns 0 on timeout while
+	// pg_advisory_lock blocks until the context is done
+	var got sql.NullInt64
+	err = dedicated.QueryRowContext(lockCtx, lockSQL, args...).Scan(&got)
+	if err == nil && got.Valid && got.Int64 != 1 {
+		err = ErrLocked
+	}
+	if err != nil {
+		_ = dedicated.Close()
+		if lockCtx.Err() != nil && ctx.Err() == nil {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+
+	return func() {
+		_, _ = dedicated.ExecContext(context.Background(), unlockSQL, args[0])
+		_ = dedicated.Close()
+	}, nil
+}
+
+// tableLock holds the row of a lock table. The row is refreshed while it is
+// held, a row not refreshed within LockExpiry belongs to a crashed run and is
+// taken over.
+func (m *Migrator) tableLock(ctx context.Context, conn *gorm.DB) (func(), error) {
+	table := m.conf.Table + "_lock"
+	if err := conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INT NOT NULL PRIMARY KEY, locked_at TIMESTAMP NOT NULL)", table)).Error; err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(m.conf.LockTimeout)
+	for {
+		// the primary key lets only one instance insert the row
+		err := conn.Exec(fmt.Sprintf("INSERT INTO %s (id, locked_at) VALUES (1, ?)", table), time.Now().UTC()).Error
+		if err == nil {
+			break
+		}
+		if !duplicateKey(conn, err) {
+			return nil, err
+		}
+
+		res := conn.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND locked_at < ?", table), time.Now().UTC().Add(-m.conf.LockExpiry))
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected > 0 {
+			log.Warn("migrate lock expired, taking it over", zap.String("table", table))
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrLocked
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	bg := conn.WithContext(context.WithoutCancel(conn.Statement.Context))
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(m.conf.LockExpiry / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if err := bg.Exec(fmt.Sprintf("UPDATE %s SET locked_at = ? WHERE id = 1", table), time.Now().UTC()).Error; err != nil {
+				log.Warn("migrate lock refresh failed", zap.String("table", table), zap.Error(err))
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		_ = bg.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = 1", table)).Error
+	}, nil
+}
+
+// duplicateKey reports whether err is a unique key violation, i.e. the lock
+// row exists.
+func duplicateKey(conn *gorm.DB, err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	if translator, ok := conn.Dialector.(gorm.ErrorTranslator); ok {
+		return errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey)
+	}
+	return false
+}
+
+// ForceUnlock releases the lock table of a migration run that crashed. It
+// does nothing for advisory locks, they end with their connection.
+func (m *Migrator) ForceUnlock(ctx context.Context) error {
+	conn := m.session(ctx)
+	switch conn.Dialector.Name() {
+	case "mysql", "postgres":
+		return nil
+	}
+
+	return conn.Exec(fmt.Sprintf("DELETE FROM %s_lock WHERE id = 1", m.conf.Table)).Error
+}
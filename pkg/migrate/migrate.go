@@ -0,0 +1,266 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/garfieldlw/common-golang/pkg/db"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+var ErrLocked = errors.New("another instance is migrating")
+
+// Migration is one versioned schema change, written in Go with Up and Down or
+// as SQL with UpSQL and DownSQL. Down is optional; a migration without it
+// cannot be rolled back.
+type Migration struct {
+	Version int64
+	Name    string
+
+	Up   func(tx *gorm.DB) error
+	Down func(tx *gorm.DB) error
+
+	UpSQL   string
+	DownSQL string
+}
+
+type Config struct {
+	// Table records the applied versions, schema_migrations by default.
+	Table string
+	// DryRun makes Up and Down only report what they would run.
+	DryRun bool
+	// LockTimeout bounds the wait for another instance that is migrating,
+	// one minute by default.
+	LockTimeout time.Duration
+	// LockExpiry is how long the lock row used on databases without advisory
+	// locks, like sqlite, stays valid without being refreshed, five minutes by
+	// default. It is refreshed while migrating, so only the lock of a crashed
+	// run expires.
+	LockExpiry time.Duration
+}
+
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+type Migrator struct {
+	conn       *gorm.DB
+	conf       Config
+	migrations []*Migration
+}
+
+func New(conn *gorm.DB, conf Config) *Migrator {
+	if len(conf.Table) == 0 {
+		conf.Table = "schema_migrations"
+	}
+	if conf.LockTimeout <= 0 {
+		conf.LockTimeout = time.Minute
+	}
+	if conf.LockExpiry <= 0 {
+		conf.LockExpiry = 5 * time.Minute
+	}
+
+	return &Migrator{conn: conn, conf: conf}
+}
+
+// Add registers migrations. Versions must be positive and unique.
+func (m *Migrator) Add(migrations ...*Migration) error {
+	known := make(map[int64]struct{}, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = struct{}{}
+	}
+
+	for _, mig := range migrations {
+		if mig.Version <= 0 {
+			return fmt.Errorf("migration %q has no version", mig.Name)
+		}
+		if mig.Up == nil && len(mig.UpSQL) == 0 {
+			return fmt.Errorf("migration %d has no up", mig.Version)
+		}
+		if _, ok := known[mig.Version]; ok {
+			return fmt.Errorf("migration %d is defined twice", mig.Version)
+		}
+		known[mig.Version] = struct{}{}
+		m.migrations = append(m.migrations, mig)
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+	return nil
+}
+
+// Status lists every known migration and whether it was applied. It does not
+// write anything, without the version table nothing is applied.
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	applied, err := m.applied(m.session(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := &Status{Version: mig.Version, Name: mig.Name}
+		if at, ok := applied[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = &at
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// Up applies the pending migrations up to version, all of them when version
+// is 0, and returns them.
+func (m *Migrator) Up(ctx context.Context, version int64) ([]*Migration, error) {
+	var done []*Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if version > 0 && mig.Version > version {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+
+			if err = m.apply(conn, mig, true); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the last steps applied migrations and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	var done []*Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == nil && len(mig.DownSQL) == 0 {
+				return fmt.Errorf("migration %d cannot be rolled back", mig.Version)
+			}
+
+			if err = m.apply(conn, mig, false); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// session pins every statement of the migrator to the primary.
+func (m *Migrator) session(ctx context.Context) *gorm.DB {
+	return m.conn.WithContext(db.Primary(ctx))
+}
+
+// locked runs fn holding the migration lock. A dry run only reads, so it
+// neither takes the lock nor creates the version table.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	conn := m.session(ctx)
+	if m.conf.DryRun {
+		return fn(conn)
+	}
+	if err := m.ensureTable(conn); err != nil {
+		return err
+	}
+
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fn(conn)
+}
+
+func (m *Migrator) apply(conn *gorm.DB, mig *Migration, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+
+	log.Info("migrate", zap.String("direction", direction), zap.Int64("version", mig.Version), zap.String("name", mig.Name), zap.Bool("dry_run", m.conf.DryRun))
+	if m.conf.DryRun {
+		return nil
+	}
+
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if err := run(tx, mig, up); err != nil {
+			return err
+		}
+		if up {
+			return tx.Exec(fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (?, ?, ?)", m.conf.Table), mig.Version, mig.Name, time.Now().UTC()).Error
+		}
+		return tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE version = ?", m.conf.Table), mig.Version).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migration %d %s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+	return nil
+}
+
+func run(tx *gorm.DB, mig *Migration, up bool) error {
+	fn, query := mig.Down, mig.DownSQL
+	if up {
+		fn, query = mig.Up, mig.UpSQL
+	}
+
+	if fn != nil {
+		return fn(tx)
+	}
+	for _, stmt := range splitStatements(query, tx.Dialector.Name() == "mysql") {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) ensureTable(conn *gorm.DB) error {
+	return conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)", m.conf.Table)).Error
+}
+
+func (m *Migrator) applied(conn *gorm.DB) (map[int64]time.Time, error) {
+	if !conn.Migrator().HasTable(m.conf.Table) {
+		return map[int64]time.Time{}, nil
+	}
+
+	var rows []struct {
+		Version   int64
+		AppliedAt time.Time
+	}
+	if err := conn.Raw(fmt.Sprintf("SELECT version, applied_at FROM %s", m.conf.Table)).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
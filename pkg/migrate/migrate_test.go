@@ -0,0 +1,248 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestConn(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	conn, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "migrate.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return conn
+}
+
+func testMigrations() []*Migration {
+	return []*Migration{
+		{
+			Version: 1,
+			Name:    "users",
+			UpSQL:   "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\nINSERT INTO users (name) VALUES ('a;b');",
+			DownSQL: "DROP TABLE users;",
+		},
+		{
+			Version: 2,
+			Name:    "posts",
+			Up: func(tx *gorm.DB) error {
+				return tx.Exec("CREATE TABLE posts (id INTEGER PRIMARY KEY)").Error
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Exec("DROP TABLE posts").Error
+			},
+		},
+	}
+}
+
+func newTestMigrator(t *testing.T, conn *gorm.DB, conf Config) *Migrator {
+	t.Helper()
+
+	m := New(conn, conf)
+	if err := m.Add(testMigrations()...); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func appliedVersions(t *testing.T, m *Migrator) []int64 {
+	t.Helper()
+
+	list, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []int64
+	for _, s := range list {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func TestUpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	conn := newTestConn(t)
+	m := newTestMigrator(t, conn, Config{})
+
+	if got := appliedVersions(t, m); len(got) != 0 {
+		t.Fatalf("applied before Up = %v, want none", got)
+	}
+
+	done, err := m.Up(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Version != 1 {
+		t.Fatalf("Up(1) applied %d migrations, want version 1", len(done))
+	}
+
+	var name string
+	if err = conn.Raw("SELECT name FROM users").Scan(&name).Error; err != nil || name != "a;b" {
+		t.Fatalf("users row = %q, %v, want a;b", name, err)
+	}
+
+	if done, err = m.Up(ctx, 0); err != nil || len(done) != 1 || done[0].Version != 2 {
+		t.Fatalf("Up(0) = %d migrations, %v, want version 2", len(done), err)
+	}
+	if got := appliedVersions(t, m); len(got) != 2 {
+		t.Fatalf("applied after Up = %v, want 1 and 2", got)
+	}
+	if done, err = m.Up(ctx, 0); err != nil || len(done) != 0 {
+		t.Fatalf("Up with nothing pending = %d migrations, %v", len(done), err)
+	}
+
+	if done, err = m.Down(ctx, 1); err != nil || len(done) != 1 || done[0].Version != 2 {
+		t.Fatalf("Down(1) = %d migrations, %v, want version 2", len(done), err)
+	}
+	if conn.Migrator().HasTable("posts") {
+		t.Fatal("posts still exists after Down")
+	}
+
+	if done, err = m.Down(ctx, 5); err != nil || len(done) != 1 || done[0].Version != 1 {
+		t.Fatalf("Down(5) = %d migrations, %v, want version 1", len(done), err)
+	}
+	if got := appliedVersions(t, m); len(got) != 0 {
+		t.Fatalf("applied after Down = %v, want none", got)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	conn := newTestConn(t)
+	m := New(conn, Config{})
+	err := m.Add(&Migration{Version: 1, Name: "broken", UpSQL: "CREATE TABLE a (id INTEGER); CREATE TABLE a (id INTEGER);"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Up(context.Background(), 0); err == nil {
+		t.Fatal("Up of a broken migration succeeded")
+	}
+	if conn.Migrator().HasTable("a") {
+		t.Fatal("the statements of a failed migration were kept")
+	}
+	if got := appliedVersions(t, m); len(got) != 0 {
+		t.Fatalf("applied after failure = %v, want none", got)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	conn := newTestConn(t)
+
+	m := newTestMigrator(t, conn, Config{DryRun: true})
+	if got := appliedVersions(t, m); len(got) != 0 {
+		t.Fatalf("applied on a fresh database = %v, want none", got)
+	}
+
+	done, err := m.Up(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2 {
+		t.Fatalf("dry run reported %d migrations, want 2", len(done))
+	}
+
+	for _, table := range []string{"schema_migrations", "schema_migrations_lock", "users", "posts"} {
+		if conn.Migrator().HasTable(table) {
+			t.Fatalf("dry run and status created %s", table)
+		}
+	}
+
+	if _, err = newTestMigrator(t, conn, Config{}).Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if done, err = m.Down(ctx, 1); err != nil || len(done) != 1 || done[0].Version != 2 {
+		t.Fatalf("dry run Down(1) = %d migrations, %v, want version 2", len(done), err)
+	}
+	if !conn.Migrator().HasTable("posts") {
+		t.Fatal("dry run Down dropped posts")
+	}
+}
+
+func TestTableLock(t *testing.T) {
+	ctx := context.Background()
+	conn := newTestConn(t)
+	m := New(conn, Config{LockTimeout: 300 * time.Millisecond})
+
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.lock(ctx, conn); !errors.Is(err, ErrLocked) {
+		t.Fatalf("lock while held = %v, want ErrLocked", err)
+	}
+	if _, err = m.Up(ctx, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Up while locked = %v, want ErrLocked", err)
+	}
+
+	unlock()
+
+	unlock, err = m.lock(ctx, conn)
+	if err != nil {
+		t.Fatalf("lock after unlock = %v", err)
+	}
+	unlock()
+}
+
+func TestTableLockExpiry(t *testing.T) {
+	ctx := context.Background()
+	conn := newTestConn(t)
+	m := New(conn, Config{LockTimeout: 300 * time.Millisecond, LockExpiry: time.Minute})
+
+	// the row of a run that crashed an hour ago
+	err := conn.Exec("CREATE TABLE schema_migrations_lock (id INT NOT NULL PRIMARY KEY, locked_at TIMESTAMP NOT NULL)").Error
+	if err == nil {
+		err = conn.Exec("INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, ?)", time.Now().UTC().Add(-time.Hour)).Error
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		t.Fatalf("lock with a stale row = %v", err)
+	}
+	unlock()
+
+	// a fresh row is not taken over
+	if err = conn.Exec("INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, ?)", time.Now().UTC()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.lock(ctx, conn); !errors.Is(err, ErrLocked) {
+		t.Fatalf("lock with a fresh row = %v, want ErrLocked", err)
+	}
+}
+
+func TestTableLockError(t *testing.T) {
+	conn := newTestConn(t)
+	m := New(conn, Config{LockTimeout: time.Minute})
+
+	if err := conn.Exec("CREATE TABLE schema_migrations_lock (id INT NOT NULL PRIMARY KEY)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err := m.lock(context.Background(), conn)
+	if err == nil || errors.Is(err, ErrLocked) {
+		t.Fatalf("lock on a broken lock table = %v, want the insert error", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("lock retried an error that is not a held lock")
+	}
+}
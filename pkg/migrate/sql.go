@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// LoadFS adds the SQL migrations in dir of fsys, usually an embed.FS. Files
+// are named <version>_<name>.up.sql and <version>_<name>.down.sql, e.g.
+// 0001_create_users.up.sql; the down file is optional.
+func (m *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	byVersion := map[int64]*Migration{}
+	var list []*Migration
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		file := entry.Name()
+		base, up := strings.CutSuffix(file, ".up.sql")
+		if !up {
+			var down bool
+			if base, down = strings.CutSuffix(file, ".down.sql"); !down {
+				continue
+			}
+		}
+
+		versionPart, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionPart, 10, 64)
+		if err != nil {
+			return fmt.Errorf("migration file %s has no version", file)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: name}
+			byVersion[version] = mig
+			list = append(list, mig)
+		}
+		if up {
+			mig.UpSQL = string(data)
+		} else {
+			mig.DownSQL = string(data)
+		}
+	}
+
+	return m.Add(list...)
+}
+
+// splitStatements splits a SQL file at the semicolons ending a statement, so
+// drivers without multi-statement support can run it. Semicolons in quotes,
+// -- and /* */ comments and dollar-quoted bodies like $$ ... $$ or
+// $body$ ... $body$ are kept. Backslashes escape quotes in every string when
+// backslashEscapes is set, as on MySQL, and otherwise only in E'...' strings
+// as on Postgres.
+func splitStatements(query string, backslashEscapes bool) []string {
+	var stmts []string
+	start, content := 0, false
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == ';':
+			if content {
+				stmts = append(stmts, strings.TrimSpace(query[start:i]))
+			}
+			start, content = i+1, false
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			i = skipLine(query, i)
+		case strings.HasPrefix(query[i:], "/*"):
+			i = skipBlockComment(query, i)
+		case c == '\'' || c == '"' || c == '`':
+			escapes := backslashEscapes || (c == '\'' && i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && (i < 2 || !identChar(query[i-2])))
+			i = skipQuoted(query, i, escapes)
+			content = true
+		case c == '$' && len(dollarTag(query, i)) > 0:
+			tag := dollarTag(query, i)
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+			content = true
+		default:
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				content = true
+			}
+			i++
+		}
+	}
+	if content {
+		stmts = append(stmts, strings.TrimSpace(query[start:]))
+	}
+
+	return stmts
+}
+
+func skipLine(query string, i int) int {
+	end := strings.IndexByte(query[i:], '\n')
+	if end < 0 {
+		return len(query)
+	}
+	return i + end + 1
+}
+
+// skipBlockComment skips a /* */ comment, they nest on Postgres.
+func skipBlockComment(query string, i int) int {
+	depth := 0
+	for i < len(query) {
+		switch {
+		case strings.HasPrefix(query[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(query[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipQuoted skips the string or quoted identifier starting at i. A doubled
+// quote is part of it.
+func skipQuoted(query string, i int, escapes bool) int {
+	quote := query[i]
+	for i++; i < len(query); i++ {
+		switch {
+		case escapes && query[i] == '\\':
+			i++
+		case query[i] == quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return i
+}
+
+// dollarTag returns the $tag$ starting at i, "" when there is none. A $ in
+// an identifier or a $1 parameter does not start one.
+func dollarTag(query string, i int) string {
+	if i > 0 && identChar(query[i-1]) {
+		return ""
+	}
+
+	for j := i + 1; j < len(query); j++ {
+		c := query[j]
+		if c == '$' {
+			return query[i : j+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || j > i+1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+func identChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,64 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name             string
+		query            string
+		backslashEscapes bool
+		want             []string
+	}{
+		{
+			name:  "plain",
+			query: "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			want:  []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			name:  "quotes",
+			query: `INSERT INTO a VALUES ('x;y', "z;", 'it''s;');SELECT 1`,
+			want:  []string{`INSERT INTO a VALUES ('x;y', "z;", 'it''s;')`, "SELECT 1"},
+		},
+		{
+			name:  "comments",
+			query: "-- first; statement\nSELECT 1; /* a; /* nested; */ b; */ SELECT 2;\n-- trailing;",
+			want:  []string{"-- first; statement\nSELECT 1", "/* a; /* nested; */ b; */ SELECT 2"},
+		},
+		{
+			name:  "dollar quotes",
+			query: "CREATE FUNCTION f() RETURNS INT AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql;\nCREATE FUNCTION g() RETURNS INT AS $body$ SELECT '$$;'; $body$ LANGUAGE sql;",
+			want: []string{
+				"CREATE FUNCTION f() RETURNS INT AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql",
+				"CREATE FUNCTION g() RETURNS INT AS $body$ SELECT '$$;'; $body$ LANGUAGE sql",
+			},
+		},
+		{
+			name:  "parameters are not dollar quotes",
+			query: "PREPARE p AS SELECT $1;SELECT 2",
+			want:  []string{"PREPARE p AS SELECT $1", "SELECT 2"},
+		},
+		{
+			name:             "backslash escapes",
+			query:            `INSERT INTO a VALUES ('it\'s;');SELECT 1`,
+			backslashEscapes: true,
+			want:             []string{`INSERT INTO a VALUES ('it\'s;')`, "SELECT 1"},
+		},
+		{
+			name:  "standard strings keep backslashes",
+			query: `INSERT INTO a VALUES ('C:\');SELECT E'it\'s;'`,
+			want:  []string{`INSERT INTO a VALUES ('C:\')`, `SELECT E'it\'s;'`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.query, tt.backslashEscapes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitStatements = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
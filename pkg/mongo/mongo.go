@@ -4,12 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/garfieldlw/common-golang/pkg/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,16 +22,21 @@ const (
 )
 
 var mu sync.RWMutex
+var mongoConfig atomic.Pointer[ConfigItem]
 
 type mongoData struct {
 	Client *mongo.Client
 	pos    int
 	flag   bool
+	// gen is the config generation the client was connected with
+	gen int64
 }
 
 type ClientPool struct {
 	clientList [MaxConnection]mongoData
 	size       int
+	// gen is bumped by SetConfig, slots of an older one are reconnected
+	gen int64
 }
 
 var cp ClientPool
@@ -76,9 +84,36 @@ func (cp *ClientPool) allocateToPool(pos int) (err error) {
 
 	cp.clientList[pos].flag = true
 	cp.clientList[pos].pos = pos
+	cp.clientList[pos].gen = cp.gen
 	return nil
 }
 
+// refresh reconnects the slot pos, reserved by its flag so it is not handed
+// out meanwhile, with the current config and puts it back. It runs without
+// the lock, connecting may take long.
+func (cp *ClientPool) refresh(pos int, gen int64) {
+	client, err := connect()
+
+	mu.Lock()
+	slot := &cp.clientList[pos]
+	if err != nil {
+		// keep the old connection, the next release retries
+		slot.flag = false
+		mu.Unlock()
+		log.Error("mongo reconnect failed", zap.Int("pos", pos), zap.Error(err))
+		return
+	}
+	old := slot.Client
+	slot.Client = client
+	slot.gen = gen
+	slot.flag = false
+	mu.Unlock()
+
+	if old != nil {
+		_ = disconnect(old)
+	}
+}
+
 func (cp *ClientPool) getToPool(pos int) {
 	cp.clientList[pos].flag = true
 }
@@ -88,17 +123,18 @@ func (cp *ClientPool) putBackPool(pos int) {
 }
 
 func GetClient() (*mongoData, error) {
-	mu.RLock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	// the client is marked as taken, so SetConfig does not reconnect it
+	// while in use
 	for i := 1; i < cp.size; i++ {
 		if cp.clientList[i].flag == false {
-			mu.RUnlock()
+			cp.getToPool(i)
 			return &cp.clientList[i], nil
 		}
 	}
-	mu.RUnlock()
 
-	mu.Lock()
-	defer mu.Unlock()
 	if cp.size < MaxConnection {
 		err := cp.allocateToPool(cp.size)
 		if err != nil {
@@ -113,10 +149,17 @@ func GetClient() (*mongoData, error) {
 	}
 }
 
+// ReleaseClient puts c back. A client connected before the last SetConfig
+// stays taken until it is reconnected in the background.
 func ReleaseClient(c *mongoData) {
 	mu.Lock()
-	defer mu.Unlock()
+	if gen := cp.gen; cp.clientList[c.pos].gen != gen {
+		mu.Unlock()
+		go cp.refresh(c.pos, gen)
+		return
+	}
 	cp.putBackPool(c.pos)
+	mu.Unlock()
 }
 
 func ErrorIsNoDocuments(err error) bool {
@@ -351,7 +394,37 @@ type ConfigItem struct {
 	Password string `json:"password"`
 }
 
+// SetConfig replaces the mongo configuration, e.g. from a config.Value
+// subscriber. The idle clients of the pool are reconnected with conf and
+// their old connections closed; the clients in use are reconnected once
+// released, so clients must be taken with GetClient per operation instead
+// of being kept.
+func SetConfig(conf *ConfigItem) {
+	mongoConfig.Store(conf)
+
+	mu.Lock()
+	cp.gen++
+	gen := cp.gen
+	var idle []int
+	for pos := 0; pos < cp.size; pos++ {
+		if !cp.clientList[pos].flag {
+			// reserved until refresh put it back
+			cp.getToPool(pos)
+			idle = append(idle, pos)
+		}
+	}
+	mu.Unlock()
+
+	for _, pos := range idle {
+		cp.refresh(pos, gen)
+	}
+}
+
 func GetMongoConfig() *ConfigItem {
+	if conf := mongoConfig.Load(); conf != nil {
+		return conf
+	}
+
 	return &ConfigItem{
 		Host:     "",
 		Port:     "",
@@ -1,105 +1,78 @@
 package mysql
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/garfieldlw/common-golang/pkg/db"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
-	"gorm.io/plugin/dbresolver"
-	"sync"
-	"time"
 )
 
-type DbInfo struct {
-	DbConfig *ConfigItem
-	Conn     *gorm.DB
-}
-
-var instance *DbInfo
-var lock = &sync.Mutex{}
+// dbName is the name the pool is registered under in package db.
+const dbName = "mysql"
 
-func LoadPgDb() *DbInfo {
-	if instance != nil {
-		return instance
-	}
+var instance = db.NewInstance(dbName, (*ConfigItem).dbConfig, defaultConfig)
 
-	lock.Lock()
-	defer lock.Unlock()
-
-	if instance == nil {
-		dbConf := getMysqlConfig()
-		if dbConf == nil {
-			return nil
-		}
-
-		dnInfo := new(DbInfo)
-		dnInfo.DbConfig = dbConf
-		dnInfo.InitConnect()
+func init() {
+	db.RegisterDriver("mysql", func(dsn string) gorm.Dialector {
+		return mysql.Open(dsn)
+	})
+	db.RegisterRetryable("mysql", func(err error) bool {
+		// 1213 is a deadlock
+		var e *mysqldriver.MySQLError
+		return errors.As(err, &e) && e.Number == 1213
+	})
+}
 
-		instance = dnInfo
-	}
+type DbInfo = db.Info[ConfigItem]
 
-	return instance
+// DB returns the connection pool, e.g. for repository.NewWithProvider. It
+// is replaced by SetConfig, so it should be fetched per use.
+func DB() (*db.DB, error) {
+	return instance.DB()
 }
 
-func (info *DbInfo) InitConnect() {
-	dbConf := info.DbConfig
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True", dbConf.User, dbConf.Password, dbConf.Host, dbConf.Port, dbConf.Database)
-
-	if db, e := gorm.Open(mysql.Open(dsn), &gorm.Config{}); e != nil {
-		info.Conn = nil
-	} else {
-		if dbConf.Idle == 0 {
-			dbConf.Idle = 5
-		}
-		if dbConf.Open == 0 {
-			dbConf.Open = 20
-		}
-		_ = db.Use(dbresolver.Register(dbresolver.Config{}).SetMaxIdleConns(int(dbConf.Idle)).SetMaxOpenConns(int(dbConf.Open)).SetConnMaxLifetime(time.Second * 3300))
-		db = db.Session(&gorm.Session{})
-		info.Conn = db
-	}
+// GetDB returns the gorm handle bound to ctx, connecting first if needed,
+// or the reason it could not connect.
+func GetDB(ctx context.Context) (*gorm.DB, error) {
+	return instance.GetDB(ctx)
 }
 
-func (info *DbInfo) CheckAndReturnConn() *gorm.DB {
-	if info.Conn == nil {
-		lock.Lock()
-		defer lock.Unlock()
-		if info.Conn == nil || info.Conn.Error != nil {
-			info.InitConnect()
-		}
-	}
-	if info.Conn == nil {
-		return nil
-	}
+// State is the readiness of the connection pool, for health checks.
+func State() db.State {
+	return instance.State()
+}
 
-	return info.Conn
+// Err returns the error of the last failed connection attempt or ping.
+func Err() error {
+	return instance.Err()
 }
 
-func GetDb() *gorm.DB {
-	pgIns := LoadPgDb()
-	if pgIns == nil {
-		return nil
-	}
-	return pgIns.CheckAndReturnConn()
+// WithTx runs fn in a transaction, see db.DB.WithTx. GetDB with the context
+// passed to fn returns the transaction.
+func WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return instance.WithTx(ctx, fn)
 }
 
 func Ping() error {
-	conn := GetDb()
-	if conn == nil {
-		return errors.New("connect mysql fail")
-	}
+	return instance.Ping(context.Background())
+}
 
-	if db, err := conn.DB(); err == nil {
-		err = db.Ping()
-		if err != nil {
-			return err
-		}
-	} else {
-		return err
-	}
+// Deprecated: use GetDB, which reports why it could not connect.
+func LoadDb() *DbInfo {
+	return instance.LoadDb()
+}
 
-	return nil
+// Deprecated: use GetDB.
+func LoadPgDb() *DbInfo {
+	return LoadDb()
+}
+
+// Deprecated: use GetDB, which reports why it could not connect.
+func GetDb() *gorm.DB {
+	return instance.GetDb()
 }
 
 type ConfigItem struct {
@@ -110,9 +83,49 @@ type ConfigItem struct {
 	Database string `json:"database"`
 	Open     int64  `json:"open"`
 	Idle     int64  `json:"idle"`
+	// MaxLifetime and MaxIdleTime in seconds, see db.Config.
+	MaxLifetime int64 `json:"max_lifetime"`
+	MaxIdleTime int64 `json:"max_idle_time"`
+	// Retries and TxRetries, see db.Config.
+	Retries   int64 `json:"retries"`
+	TxRetries int64 `json:"tx_retries"`
+	// Replicas are the DSNs of the read replicas, see db.Config.
+	Replicas  []string      `json:"replicas"`
+	Policy    string        `json:"policy"`
+	Resolvers []db.Resolver `json:"resolvers"`
+	// SlowThreshold in milliseconds and LogParams, see db.Config.
+	SlowThreshold int64 `json:"slow_threshold"`
+	LogParams     bool  `json:"log_params"`
+}
+
+func (conf *ConfigItem) dbConfig() *db.Config {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True", conf.User, conf.Password, conf.Host, conf.Port, conf.Database)
+
+	return &db.Config{
+		Driver:        "mysql",
+		DSN:           dsn,
+		Open:          conf.Open,
+		Idle:          conf.Idle,
+		MaxLifetime:   conf.MaxLifetime,
+		MaxIdleTime:   conf.MaxIdleTime,
+		Retries:       conf.Retries,
+		TxRetries:     conf.TxRetries,
+		Replicas:      conf.Replicas,
+		Policy:        conf.Policy,
+		Resolvers:     conf.Resolvers,
+		SlowThreshold: conf.SlowThreshold,
+		LogParams:     conf.LogParams,
+	}
+}
+
+// SetConfig replaces the database configuration, e.g. from a config.Value
+// subscriber. The current connection pool is closed and the next GetDb call
+// connects with conf.
+func SetConfig(conf *ConfigItem) {
+	instance.SetConfig(conf)
 }
 
-func getMysqlConfig() *ConfigItem {
+func defaultConfig() *ConfigItem {
 	return &ConfigItem{
 		Host:     "127.0.0.1",
 		Port:     "3306",
@@ -0,0 +1,283 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const defaultTxRetries = 3
+
+type batchOp func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder
+
+// Batch queues wrapper level commands and sends them in one round trip,
+// either as a plain pipeline (Exec) or atomically with MULTI/EXEC (ExecTx).
+type Batch struct {
+	redis *Redis
+	ops   []batchOp
+}
+
+// BatchResult is the outcome of one queued command.
+type BatchResult struct {
+	cmd redis.Cmder
+}
+
+func (r *Redis) Batch() *Batch {
+	return &Batch{redis: r}
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+func (b *Batch) add(op batchOp) *Batch {
+	b.ops = append(b.ops, op)
+	return b
+}
+
+func (b *Batch) Del(keys ...string) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.Del(ctx, b.redis.keys(keys)...)
+	})
+}
+
+func (b *Batch) Get(key string) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.Get(ctx, b.redis.key(key))
+	})
+}
+
+func (b *Batch) Set(key string, value any, expire time.Duration) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.Set(ctx, b.redis.key(key), value, expire)
+	})
+}
+
+func (b *Batch) SetNX(key string, value any, expire time.Duration) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.SetNX(ctx, b.redis.key(key), value, expire)
+	})
+}
+
+func (b *Batch) HGet(key, field string) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.HGet(ctx, b.redis.key(key), field)
+	})
+}
+
+func (b *Batch) HGetAll(key string) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.HGetAll(ctx, b.redis.key(key))
+	})
+}
+
+func (b *Batch) HSet(key, field, value string, expire time.Duration) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		cmd := pipe.HSet(ctx, b.redis.key(key), field, value)
+		if expire > 0 {
+			pipe.Expire(ctx, b.redis.key(key), expire)
+		}
+		return cmd
+	})
+}
+
+func (b *Batch) HDel(key string, field ...string) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.HDel(ctx, b.redis.key(key), field...)
+	})
+}
+
+func (b *Batch) ZAdd(key string, score float64, data string) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.ZAdd(ctx, b.redis.key(key), redis.Z{Score: score, Member: data})
+	})
+}
+
+func (b *Batch) ZRem(key string, members ...any) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.ZRem(ctx, b.redis.key(key), members...)
+	})
+}
+
+func (b *Batch) RPush(key string, values ...any) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.RPush(ctx, b.redis.key(key), values...)
+	})
+}
+
+func (b *Batch) LPush(key string, values ...any) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.LPush(ctx, b.redis.key(key), values...)
+	})
+}
+
+func (b *Batch) Expire(key string, expire time.Duration) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.Expire(ctx, b.redis.key(key), expire)
+	})
+}
+
+func (b *Batch) Incr(key string) *Batch {
+	return b.add(func(ctx context.Context, pipe redis.Pipeliner) redis.Cmder {
+		return pipe.Incr(ctx, b.redis.key(key))
+	})
+}
+
+// Exec sends the queued commands as a pipeline. The results are returned in
+// queue order; the error is the first failure other than a missing key.
+func (b *Batch) Exec(ctx context.Context) ([]*BatchResult, error) {
+	return b.run(ctx, b.redis.Client.Pipeline())
+}
+
+// ExecTx sends the queued commands wrapped in MULTI/EXEC.
+func (b *Batch) ExecTx(ctx context.Context) ([]*BatchResult, error) {
+	return b.run(ctx, b.redis.Client.TxPipeline())
+}
+
+func (b *Batch) run(ctx context.Context, pipe redis.Pipeliner) ([]*BatchResult, error) {
+	if len(b.ops) == 0 {
+		return nil, nil
+	}
+
+	results := make([]*BatchResult, 0, len(b.ops))
+	for _, op := range b.ops {
+		results = append(results, &BatchResult{cmd: op(ctx, pipe)})
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return results, err
+	}
+
+	for _, result := range results {
+		if err = result.Err(); err != nil && !errors.Is(err, ErrNotFound) {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// Tx is handed to the Transaction callback. Reads go straight to redis on the
+// watched connection, writes are queued on the embedded Batch and committed
+// with MULTI/EXEC when the callback returns.
+type Tx struct {
+	*Batch
+	tx *redis.Tx
+}
+
+func (t *Tx) Get(ctx context.Context, key string) (string, error) {
+	return t.tx.Get(ctx, t.redis.key(key)).Result()
+}
+
+func (t *Tx) HGet(ctx context.Context, key, field string) (string, error) {
+	return t.tx.HGet(ctx, t.redis.key(key), field).Result()
+}
+
+func (t *Tx) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return t.tx.HGetAll(ctx, t.redis.key(key)).Result()
+}
+
+func (t *Tx) ZScore(ctx context.Context, key, member string) (float64, error) {
+	return t.tx.ZScore(ctx, t.redis.key(key), member).Result()
+}
+
+// Transaction watches keys, runs fn and commits the commands fn queued. When
+// a watched key is modified before EXEC, fn is run again, up to maxRetries
+// times (3 when maxRetries <= 0).
+func (r *Redis) Transaction(ctx context.Context, keys []string, maxRetries int, fn func(ctx context.Context, tx *Tx) error) ([]*BatchResult, error) {
+	if maxRetries <= 0 {
+		maxRetries = defaultTxRetries
+	}
+
+	var results []*BatchResult
+	for i := 0; i < maxRetries; i++ {
+		err := r.Client.Watch(ctx, func(tx *redis.Tx) error {
+			t := &Tx{Batch: &Batch{redis: r}, tx: tx}
+			if err := fn(ctx, t); err != nil {
+				return err
+			}
+
+			var err error
+			results, err = t.run(ctx, tx.TxPipeline())
+			return err
+		}, r.keys(keys)...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return results, err
+		}
+
+		sleepContext(ctx, time.Duration(i+1)*10*time.Millisecond)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, redis.TxFailedErr
+}
+
+func (r *BatchResult) Name() string {
+	return r.cmd.Name()
+}
+
+// Err returns the command error, ErrNotFound for missing keys.
+func (r *BatchResult) Err() error {
+	err := r.cmd.Err()
+	if errors.Is(err, redis.Nil) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *BatchResult) Text() (string, error) {
+	if err := r.Err(); err != nil {
+		return "", err
+	}
+
+	switch cmd := r.cmd.(type) {
+	case *redis.StringCmd:
+		return cmd.Val(), nil
+	case *redis.StatusCmd:
+		return cmd.Val(), nil
+	}
+	return "", errors.New("redis: " + r.cmd.Name() + " result is not a string")
+}
+
+func (r *BatchResult) Int() (int64, error) {
+	if err := r.Err(); err != nil {
+		return 0, err
+	}
+
+	switch cmd := r.cmd.(type) {
+	case *redis.IntCmd:
+		return cmd.Val(), nil
+	case *redis.StringCmd:
+		return cmd.Int64()
+	}
+	return 0, errors.New("redis: " + r.cmd.Name() + " result is not an integer")
+}
+
+func (r *BatchResult) Bool() (bool, error) {
+	if err := r.Err(); err != nil {
+		return false, err
+	}
+
+	switch cmd := r.cmd.(type) {
+	case *redis.BoolCmd:
+		return cmd.Val(), nil
+	case *redis.IntCmd:
+		return cmd.Val() > 0, nil
+	}
+	return false, errors.New("redis: " + r.cmd.Name() + " result is not a bool")
+}
+
+func (r *BatchResult) StringMap() (map[string]string, error) {
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+
+	if cmd, ok := r.cmd.(*redis.MapStringStringCmd); ok {
+		return cmd.Val(), nil
+	}
+	return nil, errors.New("redis: " + r.cmd.Name() + " result is not a map")
+}
@@ -0,0 +1,172 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+func (r *Redis) PFAdd(ctx context.Context, key string, els ...any) error {
+	return r.Client.PFAdd(ctx, r.key(key), els...).Err()
+}
+
+func (r *Redis) PFCount(ctx context.Context, keys ...string) (int64, error) {
+	return r.Client.PFCount(ctx, r.keys(keys)...).Result()
+}
+
+func (r *Redis) PFMerge(ctx context.Context, dest string, keys ...string) error {
+	return r.Client.PFMerge(ctx, r.key(dest), r.keys(keys)...).Err()
+}
+
+func (r *Redis) SetBit(ctx context.Context, key string, offset int64, value int) error {
+	return r.Client.SetBit(ctx, r.key(key), offset, value).Err()
+}
+
+func (r *Redis) GetBit(ctx context.Context, key string, offset int64) (int64, error) {
+	return r.Client.GetBit(ctx, r.key(key), offset).Result()
+}
+
+func (r *Redis) BitCount(ctx context.Context, key string) (int64, error) {
+	return r.Client.BitCount(ctx, r.key(key), nil).Result()
+}
+
+func (r *Redis) BitOpAnd(ctx context.Context, dest string, keys ...string) error {
+	return r.Client.BitOpAnd(ctx, r.key(dest), r.keys(keys)...).Err()
+}
+
+func (r *Redis) BitOpOr(ctx context.Context, dest string, keys ...string) error {
+	return r.Client.BitOpOr(ctx, r.key(dest), r.keys(keys)...).Err()
+}
+
+// offsetScript maps an id to a dense bitmap offset, allocating the next free
+// one on first use.
+var offsetScript = redis.NewScript(`
+local offset = redis.call("HGET", KEYS[1], ARGV[1])
+if offset then
+	return tonumber(offset)
+end
+offset = redis.call("HLEN", KEYS[1])
+redis.call("HSET", KEYS[1], ARGV[1], offset)
+return offset
+`)
+
+const dayLayout = "20060102"
+
+// ActiveUsers counts daily active users with one bitmap per day. Snowflake
+// ids from the unique package are far too sparse to be bit offsets, so every
+// id is first mapped to a small sequential offset kept in a redis hash; the
+// bitmaps then stay about one bit per known user.
+type ActiveUsers struct {
+	redis  *Redis
+	name   string
+	expire time.Duration
+}
+
+// NewActiveUsers returns a counter whose daily bitmaps are kept for expire,
+// forever when expire is not positive. The id to offset index never expires.
+func NewActiveUsers(r *Redis, name string, expire time.Duration) *ActiveUsers {
+	return &ActiveUsers{redis: r, name: name, expire: expire}
+}
+
+func (a *ActiveUsers) dayKey(day time.Time) string {
+	return fmt.Sprintf("%s:dau:%s", a.name, day.Format(dayLayout))
+}
+
+func (a *ActiveUsers) indexKey() string {
+	return a.name + ":dau:index"
+}
+
+func (a *ActiveUsers) offset(ctx context.Context, id int64) (int64, error) {
+	return offsetScript.Run(ctx, a.redis.Client, []string{a.redis.key(a.indexKey())}, strconv.FormatInt(id, 10)).Int64()
+}
+
+// Mark records id as active on the day of t.
+func (a *ActiveUsers) Mark(ctx context.Context, id int64, t time.Time) error {
+	offset, err := a.offset(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	key := a.dayKey(t)
+	if err = a.redis.SetBit(ctx, key, offset, 1); err != nil {
+		return err
+	}
+	if a.expire > 0 {
+		return a.redis.Expire(ctx, key, a.expire)
+	}
+	return nil
+}
+
+func (a *ActiveUsers) IsActive(ctx context.Context, id int64, day time.Time) (bool, error) {
+	offset, err := a.redis.HGet(ctx, a.indexKey(), strconv.FormatInt(id, 10))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	n, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return false, err
+	}
+
+	bit, err := a.redis.GetBit(ctx, a.dayKey(day), n)
+	return bit == 1, err
+}
+
+// Count returns the number of users active on day.
+func (a *ActiveUsers) Count(ctx context.Context, day time.Time) (int64, error) {
+	return a.redis.BitCount(ctx, a.dayKey(day))
+}
+
+// CountAny returns the number of users active on at least one day in
+// [from, to], e.g. weekly or monthly actives. Days are taken in the location
+// of from.
+func (a *ActiveUsers) CountAny(ctx context.Context, from, to time.Time) (int64, error) {
+	return a.combine(ctx, from, to, a.redis.BitOpOr)
+}
+
+// CountEvery returns the number of users active on every day in [from, to],
+// e.g. retained users.
+func (a *ActiveUsers) CountEvery(ctx context.Context, from, to time.Time) (int64, error) {
+	return a.combine(ctx, from, to, a.redis.BitOpAnd)
+}
+
+func (a *ActiveUsers) combine(ctx context.Context, from, to time.Time, op func(ctx context.Context, dest string, keys ...string) error) (int64, error) {
+	// whole days, a to earlier in its day than from must still count
+	from = startOfDay(from, from.Location())
+	to = startOfDay(to, from.Location())
+
+	var keys []string
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		keys = append(keys, a.dayKey(day))
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, err
+	}
+	dest := fmt.Sprintf("%s:dau:tmp:%s", a.name, hex.EncodeToString(suffix))
+	defer func() {
+		_ = a.redis.Del(context.WithoutCancel(ctx), dest)
+	}()
+
+	if err := op(ctx, dest, keys...); err != nil {
+		return 0, err
+	}
+	return a.redis.BitCount(ctx, dest)
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,130 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// maxBitmapBits is the largest bitmap redis can hold, 512MB.
+const maxBitmapBits = uint64(1) << 32
+
+// BloomFilter is a Bloom filter stored in a plain redis bitmap, so it needs
+// no server module. Adding is idempotent and items can never be removed.
+type BloomFilter struct {
+	redis  *Redis
+	key    string
+	bits   uint64
+	hashes uint64
+	expire time.Duration
+}
+
+// NewBloomFilter sizes a filter for capacity items with the given false
+// positive rate. The rate only holds while the filter has at most capacity
+// items; it degrades quickly beyond that. expire, when positive, is applied to
+// the key on every add.
+func NewBloomFilter(r *Redis, key string, capacity uint64, falsePositiveRate float64, expire time.Duration) (*BloomFilter, error) {
+	if capacity == 0 {
+		return nil, errors.New("bloom filter capacity must be positive")
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, errors.New("bloom filter false positive rate must be in (0, 1)")
+	}
+
+	// m = -n*ln(p)/ln(2)^2, k = m/n*ln(2)
+	bits := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	if bits > float64(maxBitmapBits) {
+		return nil, errors.New("bloom filter does not fit in a redis bitmap")
+	}
+	hashes := math.Max(1, math.Round(bits/float64(capacity)*math.Ln2))
+
+	return &BloomFilter{
+		redis:  r,
+		key:    key,
+		bits:   uint64(bits),
+		hashes: uint64(hashes),
+		expire: expire,
+	}, nil
+}
+
+// Add inserts items.
+func (f *BloomFilter) Add(ctx context.Context, items ...string) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	pipe := f.redis.Client.Pipeline()
+	key := f.redis.key(f.key)
+	for _, item := range items {
+		for _, offset := range f.offsets(item) {
+			pipe.SetBit(ctx, key, int64(offset), 1)
+		}
+	}
+	if f.expire > 0 {
+		pipe.Expire(ctx, key, f.expire)
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Exists reports for every item whether it may have been added. False is
+// always correct, true is wrong at about the configured rate.
+func (f *BloomFilter) Exists(ctx context.Context, items ...string) ([]bool, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	pipe := f.redis.Client.Pipeline()
+	key := f.redis.key(f.key)
+	cmds := make([][]*redis.IntCmd, len(items))
+	for i, item := range items {
+		for _, offset := range f.offsets(item) {
+			cmds[i] = append(cmds[i], pipe.GetBit(ctx, key, int64(offset)))
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	list := make([]bool, len(items))
+	for i := range items {
+		list[i] = true
+		for _, cmd := range cmds[i] {
+			if cmd.Val() == 0 {
+				list[i] = false
+				break
+			}
+		}
+	}
+
+	return list, nil
+}
+
+func (f *BloomFilter) Reset(ctx context.Context) error {
+	return f.redis.Del(ctx, f.key)
+}
+
+// offsets derives the k bit positions of item with double hashing over the
+// two halves of a 128 bit FNV-1a hash.
+func (f *BloomFilter) offsets(item string) []uint64 {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(item))
+	sum := h.Sum(nil)
+
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[i+8])
+	}
+
+	offsets := make([]uint64, f.hashes)
+	for i := uint64(0); i < f.hashes; i++ {
+		offsets[i] = (h1 + i*h2) % f.bits
+	}
+	return offsets
+}
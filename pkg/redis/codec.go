@@ -0,0 +1,217 @@
+package redis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"reflect"
+	"time"
+)
+
+// ErrNotFound is returned by the typed accessors when a key or field does not
+// exist. It matches redis.Nil with errors.Is for callers that still check it.
+var ErrNotFound error = notFoundError{}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string {
+	return "redis: key not found"
+}
+
+func (notFoundError) Is(target error) bool {
+	return target == redis.Nil
+}
+
+// Codec converts values to and from the bytes stored in redis. JSONCodec and
+// MsgpackCodec take any type. ProtobufCodec takes proto messages, use the
+// generated pointer type, e.g. GetAs[*pb.User], messages must not be copied.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.New("value is not a proto.Message")
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return errors.New("value is not a proto.Message")
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// GzipCodec compresses the output of inner with gzip.
+func GzipCodec(inner Codec) Codec {
+	return gzipCodec{inner: inner}
+}
+
+type gzipCodec struct {
+	inner Codec
+}
+
+func (c gzipCodec) Marshal(v any) ([]byte, error) {
+	data, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c gzipCodec) Unmarshal(data []byte, v any) error {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return c.inner.Unmarshal(raw, v)
+}
+
+// GetAs loads key and decodes it into a T with codec.
+func GetAs[T any](ctx context.Context, r *Redis, codec Codec, key string) (T, error) {
+	var v T
+
+	data, err := r.Client.Get(ctx, r.key(key)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return v, ErrNotFound
+		}
+		return v, err
+	}
+
+	err = decode(codec, data, &v)
+	return v, err
+}
+
+// SetAs encodes value with codec and saves it under key.
+func SetAs(ctx context.Context, r *Redis, codec Codec, key string, value any, expire time.Duration) error {
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return r.Client.Set(ctx, r.key(key), data, expire).Err()
+}
+
+// MGetAs loads keys in one round trip. The result has one entry per key, nil
+// for keys that do not exist.
+func MGetAs[T any](ctx context.Context, r *Redis, codec Codec, keys []string) ([]*T, error) {
+	values, err := r.Client.MGet(ctx, r.keys(keys)...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*T, len(values))
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		v := new(T)
+		if err = decode(codec, []byte(raw), v); err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+
+	return list, nil
+}
+
+// decode unmarshals data into v. When T is a pointer type a new value is
+// allocated and decoded into, so a *pb.User is filled instead of a **pb.User.
+func decode[T any](codec Codec, data []byte, v *T) error {
+	t := reflect.TypeOf(v).Elem()
+	if t.Kind() != reflect.Pointer {
+		return codec.Unmarshal(data, v)
+	}
+
+	elem := reflect.New(t.Elem())
+	if err := codec.Unmarshal(data, elem.Interface()); err != nil {
+		return err
+	}
+	reflect.ValueOf(v).Elem().Set(elem)
+	return nil
+}
+
+func GetJSON[T any](ctx context.Context, r *Redis, key string) (T, error) {
+	return GetAs[T](ctx, r, JSONCodec, key)
+}
+
+func SetJSON(ctx context.Context, r *Redis, key string, value any, expire time.Duration) error {
+	return SetAs(ctx, r, JSONCodec, key, value, expire)
+}
+
+func MGetJSON[T any](ctx context.Context, r *Redis, keys []string) ([]*T, error) {
+	return MGetAs[T](ctx, r, JSONCodec, keys)
+}
+
+// HGetAllInto loads the hash at key into dst, a pointer to a struct whose
+// fields are tagged with `redis:"field"`.
+func HGetAllInto(ctx context.Context, r *Redis, key string, dst any) error {
+	cmd := r.Client.HGetAll(ctx, r.key(key))
+	values, err := cmd.Result()
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return ErrNotFound
+	}
+
+	return cmd.Scan(dst)
+}
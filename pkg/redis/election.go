@@ -0,0 +1,177 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ExpireIfValue resets the expiration of key only while it still holds value,
+// the renewal of a lease owned by value.
+func (r *Redis) ExpireIfValue(ctx context.Context, key, value string, expire time.Duration) (bool, error) {
+	n, err := renewScript.Run(ctx, r.Client, []string{r.key(key)}, value, expire.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// DelIfValue deletes key only while it still holds value.
+func (r *Redis) DelIfValue(ctx context.Context, key, value string) (bool, error) {
+	n, err := releaseScript.Run(ctx, r.Client, []string{r.key(key)}, value).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+type ElectorConfig struct {
+	// Key is the redis key holding the leader lease.
+	Key string
+	// ID identifies this instance, e.g. hostname or pod name.
+	ID string
+	// TTL is the lease duration, RenewInterval must be well below it. Each
+	// renewal is bounded by RenewInterval, and leadership is given up when the
+	// next renewal could land after the lease expired.
+	TTL           time.Duration
+	RenewInterval time.Duration
+	// OnElected is called when this instance becomes leader. Its context is
+	// cancelled when leadership is lost.
+	OnElected func(ctx context.Context)
+	// OnRevoked is called when this instance stops being leader.
+	OnRevoked func()
+}
+
+// Elector campaigns for a leader lease stored in a single redis key.
+type Elector struct {
+	redis  *Redis
+	conf   ElectorConfig
+	leader atomic.Bool
+}
+
+func NewElector(r *Redis, conf ElectorConfig) (*Elector, error) {
+	if len(conf.Key) == 0 || len(conf.ID) == 0 {
+		return nil, errors.New("elector key and id are required")
+	}
+
+	if conf.TTL <= 0 {
+		conf.TTL = 15 * time.Second
+	}
+	if conf.RenewInterval <= 0 || conf.RenewInterval >= conf.TTL {
+		conf.RenewInterval = conf.TTL / 3
+	}
+
+	return &Elector{redis: r, conf: conf}, nil
+}
+
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns until ctx is cancelled. The lease is released on return so
+// another instance can take over without waiting for it to expire.
+func (e *Elector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.conf.RenewInterval)
+	defer ticker.Stop()
+
+	var cancel context.CancelFunc
+	var wg sync.WaitGroup
+
+	revoke := func() {
+		if !e.leader.Swap(false) {
+			return
+		}
+		cancel()
+		wg.Wait()
+		if e.conf.OnRevoked != nil {
+			e.conf.OnRevoked()
+		}
+	}
+
+	// the leader work is stopped before the lease is released, so no other
+	// instance becomes leader while it still runs
+	defer func() {
+		wasLeader := e.leader.Load()
+		revoke()
+		if wasLeader {
+			_, _ = e.redis.DelIfValue(context.WithoutCancel(ctx), e.conf.Key, e.conf.ID)
+		}
+	}()
+
+	var renewedAt time.Time
+	for {
+		// the lease runs from when the command was sent at the latest
+		sentAt := time.Now()
+		held, err := e.tryAcquire(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warn("redis elector campaign failed", zap.String("key", e.conf.Key), zap.Error(err))
+			}
+			// keep leading only while the next attempt still lands before
+			// the lease expires, another instance may take over from then on
+			held = e.leader.Load() && time.Since(renewedAt)+e.conf.RenewInterval < e.conf.TTL
+		} else if held {
+			renewedAt = sentAt
+		}
+
+		if held && !e.leader.Load() {
+			e.leader.Store(true)
+			cancel = e.elected(ctx, &wg)
+		} else if !held {
+			revoke()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// elected starts OnElected with a context that is cancelled on revocation.
+func (e *Elector) elected(ctx context.Context, wg *sync.WaitGroup) context.CancelFunc {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	if e.conf.OnElected != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.conf.OnElected(leaderCtx)
+		}()
+	}
+	return cancel
+}
+
+// tryAcquire renews the lease when this instance holds it, otherwise tries to
+// take it over.
+func (e *Elector) tryAcquire(ctx context.Context) (bool, error) {
+	// a partition must not block past the lease, a stuck call would keep
+	// this instance leader after another one took over
+	ctx, cancel := context.WithTimeout(ctx, e.conf.RenewInterval)
+	defer cancel()
+
+	if e.leader.Load() {
+		return e.redis.ExpireIfValue(ctx, e.conf.Key, e.conf.ID, e.conf.TTL)
+	}
+
+	return e.redis.SetNX(ctx, e.conf.Key, e.conf.ID, e.conf.TTL)
+}
@@ -0,0 +1,199 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSlowThreshold = 100 * time.Millisecond
+
+const pipelineCommand = "pipeline"
+
+// blockingCommands wait on the server by design and are left out of the slow log.
+var blockingCommands = map[string]bool{
+	"blpop":      true,
+	"brpop":      true,
+	"blmove":     true,
+	"bzpopmin":   true,
+	"bzpopmax":   true,
+	"xread":      true,
+	"xreadgroup": true,
+}
+
+// HookConfig controls the instrumentation hook.
+type HookConfig struct {
+	// SlowThreshold is the latency above which a command is logged.
+	SlowThreshold time.Duration
+	// LogArgs logs the full arguments of slow commands. By default only the
+	// command name and key are logged and every other argument is redacted.
+	LogArgs bool
+}
+
+// CommandStats are the counters kept per command name.
+type CommandStats struct {
+	Calls        int64         `json:"calls"`
+	Errors       int64         `json:"errors"`
+	TotalLatency time.Duration `json:"total_latency"`
+	MaxLatency   time.Duration `json:"max_latency"`
+}
+
+// Stats is a point in time copy of the command counters and pool statistics,
+// meant to be read periodically by a metrics exporter.
+type Stats struct {
+	Commands map[string]CommandStats `json:"commands"`
+	Pool     *redis.PoolStats        `json:"pool"`
+}
+
+// Metrics is a go-redis hook that logs slow commands and counts latency and
+// errors per command.
+type Metrics struct {
+	conf HookConfig
+
+	mu       sync.Mutex
+	commands map[string]*CommandStats
+}
+
+var _ redis.Hook = (*Metrics)(nil)
+
+func NewMetrics(conf HookConfig) *Metrics {
+	if conf.SlowThreshold <= 0 {
+		conf.SlowThreshold = defaultSlowThreshold
+	}
+	return &Metrics{conf: conf, commands: make(map[string]*CommandStats)}
+}
+
+// Instrument installs a Metrics hook on the client. GetRedis and InitRedis do
+// this for the shared instance.
+func (r *Redis) Instrument(conf HookConfig) *Metrics {
+	m := NewMetrics(conf)
+	r.Client.AddHook(m)
+	r.metrics = m
+	return m
+}
+
+// Stats returns the command counters, nil when the client is not
+// instrumented, and the connection pool statistics.
+func (r *Redis) Stats() *Stats {
+	stats := &Stats{Pool: r.Client.PoolStats()}
+	if r.metrics != nil {
+		stats.Commands = r.metrics.Snapshot()
+	}
+	return stats
+}
+
+func (m *Metrics) Snapshot() map[string]CommandStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]CommandStats, len(m.commands))
+	for name, stats := range m.commands {
+		snapshot[name] = *stats
+	}
+	return snapshot
+}
+
+func (m *Metrics) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			log.Warn("redis dial failed", zap.String("addr", addr), zap.Error(err))
+		}
+		return conn, err
+	}
+}
+
+func (m *Metrics) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		cost := time.Since(start)
+
+		m.record(cmd.Name(), cost, isFailure(err))
+		if cost >= m.conf.SlowThreshold && !blockingCommands[cmd.Name()] {
+			log.Warn("redis slow command", zap.String("cmd", m.format(cmd)), zap.Duration("cost", cost), zap.Error(err))
+		}
+		return err
+	}
+}
+
+func (m *Metrics) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		cost := time.Since(start)
+
+		failed := isFailure(err)
+		for _, cmd := range cmds {
+			if isFailure(cmd.Err()) {
+				failed = true
+				break
+			}
+		}
+
+		m.record(pipelineCommand, cost, failed)
+		if cost >= m.conf.SlowThreshold {
+			names := make([]string, 0, len(cmds))
+			for _, cmd := range cmds {
+				names = append(names, m.format(cmd))
+			}
+			log.Warn("redis slow pipeline", zap.Strings("cmds", names), zap.Duration("cost", cost), zap.Error(err))
+		}
+		return err
+	}
+}
+
+func (m *Metrics) record(name string, cost time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.commands[name]
+	if !ok {
+		stats = new(CommandStats)
+		m.commands[name] = stats
+	}
+
+	stats.Calls++
+	if failed {
+		stats.Errors++
+	}
+	stats.TotalLatency += cost
+	if cost > stats.MaxLatency {
+		stats.MaxLatency = cost
+	}
+}
+
+// format renders cmd for the slow log. Unless LogArgs is set only the command
+// name and its first argument, usually the key, are kept.
+func (m *Metrics) format(cmd redis.Cmder) string {
+	args := cmd.Args()
+	if len(args) == 0 {
+		return cmd.Name()
+	}
+
+	name := strings.ToLower(fmt.Sprint(args[0]))
+	if name == "auth" || name == "hello" {
+		return name + " [redacted]"
+	}
+
+	parts := make([]string, 0, len(args))
+	for i, arg := range args {
+		if m.conf.LogArgs || i < 2 {
+			parts = append(parts, fmt.Sprint(arg))
+			continue
+		}
+		parts = append(parts, "?")
+	}
+	return strings.Join(parts, " ")
+}
+
+func isFailure(err error) bool {
+	return err != nil && !errors.Is(err, redis.Nil)
+}
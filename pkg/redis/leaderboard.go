@@ -0,0 +1,274 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"time"
+)
+
+type LeaderboardPeriod int
+
+const (
+	PeriodNone LeaderboardPeriod = iota
+	PeriodDaily
+	PeriodWeekly
+)
+
+// tie break factors: the score is multiplied by the factor and the free low
+// digits hold how early the score was reached. Periodic boards count seconds
+// from the period start, the others from tieBreakEpoch.
+const (
+	periodTieFactor = 1e6
+	globalTieFactor = 1e9
+)
+
+var tieBreakEpoch = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var tieIncrScript = redis.NewScript(`
+local factor = tonumber(ARGV[3])
+local score = 0
+local current = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if current then
+	score = math.floor(tonumber(current) / factor)
+end
+score = score + tonumber(ARGV[2])
+redis.call("ZADD", KEYS[1], string.format("%.0f", score * factor + tonumber(ARGV[4])), ARGV[1])
+if tonumber(ARGV[5]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[5])
+end
+return tostring(score)
+`)
+
+type LeaderboardConfig struct {
+	Name   string
+	Period LeaderboardPeriod
+	// Retention is how long a periodic board is kept after its period ended,
+	// one period by default.
+	Retention time.Duration
+	// TieBreak ranks equal scores by who reached them first. Scores must then
+	// be integers, below 9e9 on periodic boards and 9e6 otherwise, so that the
+	// packed value stays exact in a float64.
+	TieBreak bool
+	// Location decides where days and weeks start, local time by default.
+	Location *time.Location
+}
+
+// RankEntry is a member with its 1-based rank and score.
+type RankEntry struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+	Rank   int64   `json:"rank"`
+}
+
+// Leaderboard ranks members by descending score in a sorted set. Periodic
+// boards use one key per day or week and expire on their own.
+type Leaderboard struct {
+	redis *Redis
+	conf  LeaderboardConfig
+	at    time.Time
+}
+
+func NewLeaderboard(r *Redis, conf LeaderboardConfig) *Leaderboard {
+	if conf.Location == nil {
+		conf.Location = time.Local
+	}
+	return &Leaderboard{redis: r, conf: conf}
+}
+
+// At returns the board of the period containing t, e.g. yesterday's daily
+// board. Boards returned by NewLeaderboard follow the current period.
+func (l *Leaderboard) At(t time.Time) *Leaderboard {
+	return &Leaderboard{redis: l.redis, conf: l.conf, at: t}
+}
+
+func (l *Leaderboard) now() time.Time {
+	if l.at.IsZero() {
+		return time.Now().In(l.conf.Location)
+	}
+	return l.at.In(l.conf.Location)
+}
+
+// bounds returns the start and end of the period containing t.
+func (l *Leaderboard) bounds(t time.Time) (time.Time, time.Time) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, l.conf.Location)
+	switch l.conf.Period {
+	case PeriodDaily:
+		return day, day.AddDate(0, 0, 1)
+	case PeriodWeekly:
+		offset := (int(day.Weekday()) + 6) % 7 // weeks start on monday
+		start := day.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7)
+	}
+	return time.Time{}, time.Time{}
+}
+
+func (l *Leaderboard) keyAt(t time.Time) string {
+	if l.conf.Period == PeriodNone {
+		return l.conf.Name + ":lb"
+	}
+
+	start, _ := l.bounds(t)
+	return l.conf.Name + ":lb:" + start.Format(dayLayout)
+}
+
+// expireAt returns how long the key of the period containing t should live,
+// 0 for boards that never expire.
+func (l *Leaderboard) expireAt(t time.Time) time.Duration {
+	if l.conf.Period == PeriodNone {
+		return 0
+	}
+
+	start, end := l.bounds(t)
+	retention := l.conf.Retention
+	if retention <= 0 {
+		retention = end.Sub(start)
+	}
+	return end.Add(retention).Sub(t)
+}
+
+func (l *Leaderboard) tieFactor() float64 {
+	if l.conf.Period == PeriodNone {
+		return globalTieFactor
+	}
+	return periodTieFactor
+}
+
+// tie returns the low digits packed under the score, larger for earlier t.
+func (l *Leaderboard) tie(t time.Time) float64 {
+	origin := tieBreakEpoch
+	if l.conf.Period != PeriodNone {
+		origin, _ = l.bounds(t)
+	}
+	return l.tieFactor() - 1 - math.Floor(t.Sub(origin).Seconds())
+}
+
+func (l *Leaderboard) decode(stored float64) float64 {
+	if !l.conf.TieBreak {
+		return stored
+	}
+	return math.Floor(stored / l.tieFactor())
+}
+
+// Incr adds delta to the score of member and returns the new score.
+func (l *Leaderboard) Incr(ctx context.Context, member string, delta float64) (float64, error) {
+	now := l.now()
+	key := l.redis.key(l.keyAt(now))
+	expire := l.expireAt(now)
+
+	if l.conf.TieBreak {
+		return tieIncrScript.Run(ctx, l.redis.Client, []string{key}, member, delta, l.tieFactor(), l.tie(now), expire.Milliseconds()).Float64()
+	}
+
+	pipe := l.redis.Client.TxPipeline()
+	cmd := pipe.ZIncrBy(ctx, key, delta, member)
+	if expire > 0 {
+		pipe.Expire(ctx, key, expire)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return cmd.Val(), nil
+}
+
+// SetScore replaces the score of member.
+func (l *Leaderboard) SetScore(ctx context.Context, member string, score float64) error {
+	now := l.now()
+	key := l.redis.key(l.keyAt(now))
+
+	stored := score
+	if l.conf.TieBreak {
+		stored = score*l.tieFactor() + l.tie(now)
+	}
+
+	pipe := l.redis.Client.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: stored, Member: member})
+	if expire := l.expireAt(now); expire > 0 {
+		pipe.Expire(ctx, key, expire)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (l *Leaderboard) Remove(ctx context.Context, members ...any) error {
+	return l.redis.ZRem(ctx, l.keyAt(l.now()), members...)
+}
+
+func (l *Leaderboard) Count(ctx context.Context) (int64, error) {
+	return l.redis.Client.ZCard(ctx, l.redis.key(l.keyAt(l.now()))).Result()
+}
+
+// Rank returns the rank and score of member, ErrNotFound when it has none.
+func (l *Leaderboard) Rank(ctx context.Context, member string) (*RankEntry, error) {
+	key := l.redis.key(l.keyAt(l.now()))
+
+	pipe := l.redis.Client.Pipeline()
+	rank := pipe.ZRevRank(ctx, key, member)
+	score := pipe.ZScore(ctx, key, member)
+	if _, err := pipe.Exec(ctx); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &RankEntry{Member: member, Score: l.decode(score.Val()), Rank: rank.Val() + 1}, nil
+}
+
+// Top returns the n best members.
+func (l *Leaderboard) Top(ctx context.Context, n int64) ([]*RankEntry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	return l.rangeByRank(ctx, 0, n-1)
+}
+
+// Page returns page (1-based) of the ranking and the total number of members.
+func (l *Leaderboard) Page(ctx context.Context, page, size int64) ([]*RankEntry, int64, error) {
+	if page < 1 || size <= 0 {
+		return nil, 0, errors.New("invalid page")
+	}
+
+	total, err := l.Count(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := (page - 1) * size
+	if start >= total {
+		return nil, total, nil
+	}
+
+	list, err := l.rangeByRank(ctx, start, start+size-1)
+	return list, total, err
+}
+
+// Around returns member together with up to n members ranked directly above
+// and below it.
+func (l *Leaderboard) Around(ctx context.Context, member string, n int64) ([]*RankEntry, error) {
+	entry, err := l.Rank(ctx, member)
+	if err != nil {
+		return nil, err
+	}
+
+	start := entry.Rank - 1 - n
+	if start < 0 {
+		start = 0
+	}
+	return l.rangeByRank(ctx, start, entry.Rank-1+n)
+}
+
+func (l *Leaderboard) rangeByRank(ctx context.Context, start, stop int64) ([]*RankEntry, error) {
+	list, err := l.redis.Client.ZRevRangeWithScores(ctx, l.redis.key(l.keyAt(l.now())), start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*RankEntry, 0, len(list))
+	for i, z := range list {
+		member, _ := z.Member.(string)
+		entries = append(entries, &RankEntry{Member: member, Score: l.decode(z.Score), Rank: start + int64(i) + 1})
+	}
+	return entries, nil
+}
@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const defaultScanCount = 100
+
+// Namespace returns a view of r that shares its client and prefixes every key
+// with prefix and ":". Views nest, so r.Namespace("a").Namespace("b") writes
+// under "a:b:". Commands issued directly on Client are not prefixed.
+func (r *Redis) Namespace(prefix string) *Redis {
+	return &Redis{
+		Config:  r.Config,
+		Client:  r.Client,
+		prefix:  r.prefix + prefix + ":",
+		metrics: r.metrics,
+	}
+}
+
+func (r *Redis) Prefix() string {
+	return r.prefix
+}
+
+func (r *Redis) key(key string) string {
+	return r.prefix + key
+}
+
+func (r *Redis) keys(keys []string) []string {
+	if len(r.prefix) == 0 {
+		return keys
+	}
+
+	list := make([]string, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, r.prefix+key)
+	}
+	return list
+}
+
+// Scan iterates the keys matching pattern with SCAN and calls fn for each
+// one, without the namespace prefix. Returning an error from fn stops the
+// iteration and Scan returns it. Keys may be reported more than once.
+func (r *Redis) Scan(ctx context.Context, pattern string, count int64, fn func(key string) error) error {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	var cursor uint64
+	for {
+		keys, next, err := r.Client.Scan(ctx, cursor, r.key(pattern), count).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err = fn(strings.TrimPrefix(key, r.prefix)); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// HScan iterates the fields of the hash at key that match pattern.
+func (r *Redis) HScan(ctx context.Context, key, pattern string, count int64, fn func(field, value string) error) error {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	var cursor uint64
+	for {
+		list, next, err := r.Client.HScan(ctx, r.key(key), cursor, pattern, count).Result()
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i+1 < len(list); i += 2 {
+			if err = fn(list[i], list[i+1]); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// ZScan iterates the members of the sorted set at key that match pattern.
+func (r *Redis) ZScan(ctx context.Context, key, pattern string, count int64, fn func(member string, score float64) error) error {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	var cursor uint64
+	for {
+		list, next, err := r.Client.ZScan(ctx, r.key(key), cursor, pattern, count).Result()
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i+1 < len(list); i += 2 {
+			score, err := strconv.ParseFloat(list[i+1], 64)
+			if err != nil {
+				return err
+			}
+			if err = fn(list[i], score); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// DeleteByPattern removes every key matching pattern. Keys are collected with
+// SCAN and removed with UNLINK in batches of batchSize, so the server is never
+// blocked the way KEYS or a large DEL would. A pattern matching the whole
+// database is only accepted on a namespaced view.
+func (r *Redis) DeleteByPattern(ctx context.Context, pattern string, batchSize int) (int64, error) {
+	if len(pattern) == 0 || (len(r.prefix) == 0 && strings.Trim(pattern, "*") == "") {
+		return 0, errors.New("refusing to delete every key without a namespace")
+	}
+	if batchSize <= 0 {
+		batchSize = defaultScanCount
+	}
+
+	var deleted int64
+	batch := make([]string, 0, batchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := r.Client.Unlink(ctx, batch...).Result()
+		if err != nil {
+			return err
+		}
+		deleted += n
+		batch = batch[:0]
+		return nil
+	}
+
+	err := r.Scan(ctx, pattern, int64(batchSize), func(key string) error {
+		batch = append(batch, r.key(key))
+		if len(batch) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return deleted, err
+	}
+
+	return deleted, flush()
+}
@@ -5,16 +5,21 @@ import (
 	"errors"
 	"github.com/redis/go-redis/v9"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var redisClient *Redis
+var redisClient atomic.Pointer[Redis]
+var redisConfig atomic.Pointer[ConfigItem]
 var lock *sync.Mutex = &sync.Mutex{}
 
 // Redis provides a cache backed by a Redis server.
 type Redis struct {
 	Config *redis.Options
 	Client *redis.Client
+
+	prefix  string
+	metrics *Metrics
 }
 
 // New returns an initialized Redis cache object.
@@ -24,101 +29,123 @@ func New(config *redis.Options) *Redis {
 }
 
 func (r *Redis) TTL(ctx context.Context, key string) (time.Duration, error) {
-	return r.Client.TTL(ctx, key).Result()
+	return r.Client.TTL(ctx, r.key(key)).Result()
 }
 
 func (r *Redis) Del(ctx context.Context, key string) error {
-	return r.Client.Del(ctx, key).Err()
+	return r.Client.Del(ctx, r.key(key)).Err()
 }
 
 // Get returns the value saved under a given key.
 func (r *Redis) Get(ctx context.Context, key string) (string, error) {
-	return r.Client.Get(ctx, key).Result()
+	return r.Client.Get(ctx, r.key(key)).Result()
 }
 
 func (r *Redis) GetBytes(ctx context.Context, key string) ([]byte, error) {
-	return r.Client.Get(ctx, key).Bytes()
+	return r.Client.Get(ctx, r.key(key)).Bytes()
 }
 
 func (r *Redis) MGet(ctx context.Context, keys []string) ([]any, error) {
-	return r.Client.MGet(ctx, keys...).Result()
+	return r.Client.MGet(ctx, r.keys(keys)...).Result()
 }
 
 // Set saves an arbitrary value under a specific key.
 func (r *Redis) Set(ctx context.Context, key string, value any, expire time.Duration) error {
-	return r.Client.Set(ctx, key, value, expire).Err()
+	return r.Client.Set(ctx, r.key(key), value, expire).Err()
 }
 
 func (r *Redis) SetNX(ctx context.Context, key string, value any, expire time.Duration) (bool, error) {
-	return r.Client.SetNX(ctx, key, value, expire).Result()
+	return r.Client.SetNX(ctx, r.key(key), value, expire).Result()
 }
 
 func (r *Redis) HGet(ctx context.Context, key, field string) (string, error) {
-	return r.Client.HGet(ctx, key, field).Result()
+	return r.Client.HGet(ctx, r.key(key), field).Result()
 }
 
 func (r *Redis) HGetAll(ctx context.Context, key string) (map[string]string, error) {
-	return r.Client.HGetAll(ctx, key).Result()
+	return r.Client.HGetAll(ctx, r.key(key)).Result()
 }
 
 func (r *Redis) HSet(ctx context.Context, key, field, value string, expire time.Duration) error {
-	err := r.Client.HSet(ctx, key, field, value).Err()
+	err := r.Client.HSet(ctx, r.key(key), field, value).Err()
 	if err == nil && expire > 0 {
-		r.Client.Expire(ctx, key, expire)
+		r.Client.Expire(ctx, r.key(key), expire)
 	}
 	return err
 }
 
 func (r *Redis) HSetNX(ctx context.Context, key, field, value string, expire time.Duration) error {
-	err := r.Client.HSetNX(ctx, key, field, value).Err()
+	err := r.Client.HSetNX(ctx, r.key(key), field, value).Err()
 	if err == nil && expire > 0 {
-		r.Client.Expire(ctx, key, expire)
+		r.Client.Expire(ctx, r.key(key), expire)
 	}
 	return err
 }
 
+var hsetIfExistsScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+if tonumber(ARGV[3]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// HSetIfExists sets field only while key exists, so an expired or deleted
+// hash is not recreated, and resets its expiration when expire is positive.
+// It reports whether key existed.
+func (r *Redis) HSetIfExists(ctx context.Context, key, field, value string, expire time.Duration) (bool, error) {
+	n, err := hsetIfExistsScript.Run(ctx, r.Client, []string{r.key(key)}, field, value, expire.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
 func (r *Redis) HDel(ctx context.Context, key string, field ...string) error {
-	return r.Client.HDel(ctx, key, field...).Err()
+	return r.Client.HDel(ctx, r.key(key), field...).Err()
 }
 
 func (r *Redis) ZAdd(ctx context.Context, key string, score float64, data string) error {
-	return r.Client.ZAdd(ctx, key, redis.Z{Score: score, Member: data}).Err()
+	return r.Client.ZAdd(ctx, r.key(key), redis.Z{Score: score, Member: data}).Err()
 }
 
 func (r *Redis) ZRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
-	return r.Client.ZRange(ctx, key, start, stop).Result()
+	return r.Client.ZRange(ctx, r.key(key), start, stop).Result()
 }
 
 func (r *Redis) ZCount(ctx context.Context, key, min, max string) (int64, error) {
-	return r.Client.ZCount(ctx, key, min, max).Result()
+	return r.Client.ZCount(ctx, r.key(key), min, max).Result()
 }
 
 func (r *Redis) ZRem(ctx context.Context, key string, members ...any) error {
-	return r.Client.ZRem(ctx, key, members...).Err()
+	return r.Client.ZRem(ctx, r.key(key), members...).Err()
 }
 
 func (r *Redis) RPush(ctx context.Context, key string, values ...any) error {
-	return r.Client.RPush(ctx, key, values...).Err()
+	return r.Client.RPush(ctx, r.key(key), values...).Err()
 }
 
 func (r *Redis) LPush(ctx context.Context, key string, values ...any) error {
-	return r.Client.LPush(ctx, key, values...).Err()
+	return r.Client.LPush(ctx, r.key(key), values...).Err()
 }
 
 func (r *Redis) LLen(ctx context.Context, key string) (int64, error) {
-	return r.Client.LLen(ctx, key).Result()
+	return r.Client.LLen(ctx, r.key(key)).Result()
 }
 
 func (r *Redis) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
-	return r.Client.LRange(ctx, key, start, stop).Result()
+	return r.Client.LRange(ctx, r.key(key), start, stop).Result()
 }
 
 func (r *Redis) Expire(ctx context.Context, key string, expire time.Duration) error {
-	return r.Client.Expire(ctx, key, expire).Err()
+	return r.Client.Expire(ctx, r.key(key), expire).Err()
 }
 
 func (r *Redis) Incr(ctx context.Context, key string) (int64, error) {
-	return r.Client.Incr(ctx, key).Result()
+	return r.Client.Incr(ctx, r.key(key)).Result()
 }
 
 func (r *Redis) Ping(ctx context.Context) error {
@@ -126,8 +153,8 @@ func (r *Redis) Ping(ctx context.Context) error {
 }
 
 func GetRedis() (*Redis, error) {
-	if redisClient != nil {
-		return redisClient, nil
+	if c := redisClient.Load(); c != nil {
+		return c, nil
 	}
 
 	lock.Lock()
@@ -138,41 +165,23 @@ func GetRedis() (*Redis, error) {
 		return nil, errors.New("redis config is invalid")
 	}
 
-	if redisClient != nil {
-		return redisClient, nil
+	if c := redisClient.Load(); c != nil {
+		return c, nil
 	}
 
-	redisClient = New(&redis.Options{
-		Network:     "tcp",
-		Password:    conf.Password,
-		Addr:        conf.Address,
-		DB:          int(conf.DB),
-		DialTimeout: time.Second,
-		PoolSize:    50,
-		PoolTimeout: time.Second,
-	})
+	c := newFromConfig(conf)
+	redisClient.Store(c)
 
-	return redisClient, nil
+	return c, nil
 }
 
 func InitRedis() error {
-	if redisClient != nil {
-		return nil
-	}
-
-	lock.Lock()
-	defer lock.Unlock()
-
-	conf := getRedisConfig()
-	if conf == nil {
-		return errors.New("redis config is invalid")
-	}
-
-	if redisClient != nil {
-		return nil
-	}
+	_, err := GetRedis()
+	return err
+}
 
-	redisClient = New(&redis.Options{
+func newFromConfig(conf *ConfigItem) *Redis {
+	r := New(&redis.Options{
 		Network:     "tcp",
 		Password:    conf.Password,
 		Addr:        conf.Address,
@@ -181,8 +190,9 @@ func InitRedis() error {
 		PoolSize:    50,
 		PoolTimeout: time.Second,
 	})
+	r.Instrument(HookConfig{SlowThreshold: time.Duration(conf.SlowThreshold) * time.Millisecond})
 
-	return nil
+	return r
 }
 
 func Ping(ctx context.Context) error {
@@ -203,12 +213,39 @@ type ConfigItem struct {
 	Address  string `json:"address"`
 	Password string `json:"password"`
 	DB       int32  `json:"db"`
+	// SlowThreshold is the slow command log threshold in milliseconds.
+	SlowThreshold int64 `json:"slow_threshold"`
+}
+
+// closeGrace is how long a replaced client stays open for its holders.
+const closeGrace = time.Minute
+
+// SetConfig replaces the configuration of the shared client, e.g. from a
+// config.Value subscriber. The next GetRedis call connects with conf. The
+// current client is closed after closeGrace, so calls that fetched it just
+// before can finish; long-lived holders like stream consumers, electors and
+// session stores must be rebuilt with GetRedis within that time.
+func SetConfig(conf *ConfigItem) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	redisConfig.Store(conf)
+	if old := redisClient.Swap(nil); old != nil {
+		time.AfterFunc(closeGrace, func() {
+			_ = old.Client.Close()
+		})
+	}
 }
 
 func getRedisConfig() *ConfigItem {
+	if conf := redisConfig.Load(); conf != nil {
+		return conf
+	}
+
 	return &ConfigItem{
-		Address:  "",
-		Password: "",
-		DB:       0,
+		Address:       "",
+		Password:      "",
+		DB:            0,
+		SlowThreshold: 100,
 	}
 }
@@ -0,0 +1,130 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// Job is a task run by the Scheduler on exactly one replica per tick.
+type Job struct {
+	Name string
+	// Spec is a standard five field cron expression, or a descriptor such as
+	// "@every 1m" or "@daily". @every ticks are aligned to multiples of the
+	// interval, so every replica sees the same ones.
+	Spec string
+	// LockTTL bounds how long the per-tick lock is kept, it defaults to one
+	// minute and should cover the clock skew between replicas.
+	LockTTL time.Duration
+	Run     func(ctx context.Context) error
+
+	schedule cron.Schedule
+}
+
+// Scheduler runs cron style jobs. Every replica evaluates the schedules, and
+// for each tick a redis lock keyed by job name and tick time decides which one
+// runs the job.
+type Scheduler struct {
+	redis  *Redis
+	prefix string
+	jobs   []*Job
+}
+
+func NewScheduler(r *Redis, prefix string) *Scheduler {
+	if len(prefix) == 0 {
+		prefix = "scheduler"
+	}
+	return &Scheduler{redis: r, prefix: prefix}
+}
+
+func (s *Scheduler) Add(job *Job) error {
+	if job == nil || len(job.Name) == 0 || job.Run == nil {
+		return errors.New("job name and run are required")
+	}
+
+	schedule, err := cron.ParseStandard(job.Spec)
+	if err != nil {
+		return err
+	}
+
+	if job.LockTTL <= 0 {
+		job.LockTTL = time.Minute
+	}
+	job.schedule = schedule
+	s.jobs = append(s.jobs, job)
+
+	return nil
+}
+
+// Run blocks until ctx is cancelled and waits for running jobs to return.
+func (s *Scheduler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job *Job) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *Job) {
+	for {
+		next := nextTick(job.schedule, time.Now())
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runTick(ctx, job, next)
+	}
+}
+
+// nextTick returns the tick after now. @every schedules count from the clock
+// of each process, which would give every replica its own ticks and lock keys.
+func nextTick(schedule cron.Schedule, now time.Time) time.Time {
+	if every, ok := schedule.(cron.ConstantDelaySchedule); ok && every.Delay > 0 {
+		return now.Truncate(every.Delay).Add(every.Delay)
+	}
+	return schedule.Next(now)
+}
+
+func (s *Scheduler) runTick(ctx context.Context, job *Job, tick time.Time) {
+	key := fmt.Sprintf("%s:%s:%d", s.prefix, job.Name, tick.Unix())
+
+	// the lock is not released after the run, so a replica that sees the tick
+	// late cannot run it a second time
+	ok, err := s.redis.SetNX(ctx, key, 1, job.LockTTL)
+	if err != nil {
+		log.Warn("scheduler lock failed", zap.String("job", job.Name), zap.Error(err))
+		return
+	}
+	if !ok {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("scheduler job panic", zap.String("job", job.Name), zap.Any("panic", r))
+		}
+	}()
+
+	start := time.Now()
+	if err = job.Run(ctx); err != nil {
+		log.Error("scheduler job failed", zap.String("job", job.Name), zap.Error(err))
+		return
+	}
+	log.Debug("scheduler job done", zap.String("job", job.Name), zap.Duration("cost", time.Since(start)))
+}
@@ -0,0 +1,38 @@
+package redis
+
+import (
+	"github.com/robfig/cron/v3"
+	"testing"
+	"time"
+)
+
+func TestNextTickEvery(t *testing.T) {
+	schedule, err := cron.ParseStandard("@every 1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	want := base.Add(time.Minute)
+
+	// replicas whose clocks read different times within a minute agree on
+	// the tick, and so on the lock key
+	for _, offset := range []time.Duration{0, time.Millisecond, 17 * time.Second, 59*time.Second + 999*time.Millisecond} {
+		if got := nextTick(schedule, base.Add(offset)); !got.Equal(want) {
+			t.Fatalf("nextTick at +%s = %s, want %s", offset, got, want)
+		}
+	}
+}
+
+func TestNextTickCron(t *testing.T) {
+	schedule, err := cron.ParseStandard("*/5 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 5, 1, 10, 3, 12, 0, time.UTC)
+	want := time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC)
+	if got := nextTick(schedule, now); !got.Equal(want) {
+		t.Fatalf("nextTick = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,317 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+const streamDataField = "data"
+
+func (r *Redis) XAdd(ctx context.Context, stream string, maxLen int64, values map[string]any) (string, error) {
+	return r.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.key(stream),
+		MaxLen: maxLen,
+		Approx: maxLen > 0,
+		Values: values,
+	}).Result()
+}
+
+func (r *Redis) XLen(ctx context.Context, stream string) (int64, error) {
+	return r.Client.XLen(ctx, r.key(stream)).Result()
+}
+
+func (r *Redis) XAck(ctx context.Context, stream, group string, ids ...string) error {
+	return r.Client.XAck(ctx, r.key(stream), group, ids...).Err()
+}
+
+// XGroupCreate creates the consumer group, creating the stream if needed.
+// An already existing group is not an error.
+func (r *Redis) XGroupCreate(ctx context.Context, stream, group, start string) error {
+	err := r.Client.XGroupCreateMkStream(ctx, r.key(stream), group, start).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// StreamProducer appends JSON encoded messages of type T to a stream.
+type StreamProducer[T any] struct {
+	redis  *Redis
+	stream string
+	maxLen int64
+}
+
+// NewStreamProducer returns a producer for stream. When maxLen is positive the
+// stream is trimmed to approximately maxLen entries on every append.
+func NewStreamProducer[T any](r *Redis, stream string, maxLen int64) *StreamProducer[T] {
+	return &StreamProducer[T]{redis: r, stream: stream, maxLen: maxLen}
+}
+
+// Publish appends msg to the stream and returns the entry id.
+func (p *StreamProducer[T]) Publish(ctx context.Context, msg T) (string, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	return p.redis.XAdd(ctx, p.stream, p.maxLen, map[string]any{streamDataField: data})
+}
+
+// StreamMessage is a decoded stream entry handed to a StreamHandler.
+type StreamMessage[T any] struct {
+	ID   string
+	Data T
+}
+
+// StreamHandler processes a single message. The message is acknowledged only
+// when the handler returns nil, otherwise it stays pending and is reclaimed later.
+type StreamHandler[T any] func(ctx context.Context, msg *StreamMessage[T]) error
+
+type StreamConsumerConfig struct {
+	Stream      string
+	Group       string
+	Consumer    string
+	Concurrency int
+	// Count is the max number of entries fetched per XREADGROUP/XAUTOCLAIM call.
+	Count int64
+	// Block is how long XREADGROUP waits for new entries.
+	Block time.Duration
+	// ClaimIdle is the idle time after which a pending entry is claimed. Entries
+	// this consumer is still handling are not handed out again.
+	ClaimIdle time.Duration
+	// ClaimInterval is how often pending entries are checked with XAUTOCLAIM.
+	ClaimInterval time.Duration
+}
+
+// StreamConsumer reads a stream as a member of a consumer group and runs the
+// handler with bounded concurrency.
+type StreamConsumer[T any] struct {
+	redis   *Redis
+	conf    StreamConsumerConfig
+	handler StreamHandler[T]
+
+	// inflight holds the ids queued or being handled, XAUTOCLAIM also
+	// returns our own pending entries whose handler runs past ClaimIdle
+	mu       sync.Mutex
+	inflight map[string]struct{}
+}
+
+func NewStreamConsumer[T any](r *Redis, conf StreamConsumerConfig, handler StreamHandler[T]) (*StreamConsumer[T], error) {
+	if len(conf.Stream) == 0 || len(conf.Group) == 0 || len(conf.Consumer) == 0 {
+		return nil, errors.New("stream, group and consumer are required")
+	}
+	if handler == nil {
+		return nil, errors.New("stream handler is nil")
+	}
+
+	if conf.Concurrency <= 0 {
+		conf.Concurrency = 1
+	}
+	if conf.Count <= 0 {
+		conf.Count = 10
+	}
+	if conf.Block <= 0 {
+		conf.Block = 5 * time.Second
+	}
+	if conf.ClaimIdle <= 0 {
+		conf.ClaimIdle = time.Minute
+	}
+	if conf.ClaimInterval <= 0 {
+		conf.ClaimInterval = 30 * time.Second
+	}
+
+	return &StreamConsumer[T]{redis: r, conf: conf, handler: handler, inflight: make(map[string]struct{})}, nil
+}
+
+// Run consumes the stream until ctx is cancelled. In-flight handlers are
+// allowed to finish before Run returns.
+func (c *StreamConsumer[T]) Run(ctx context.Context) error {
+	if err := c.redis.XGroupCreate(ctx, c.conf.Stream, c.conf.Group, "0"); err != nil {
+		return err
+	}
+
+	messages := make(chan redis.XMessage)
+
+	var workers sync.WaitGroup
+	for i := 0; i < c.conf.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for msg := range messages {
+				c.process(ctx, msg)
+			}
+		}()
+	}
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		c.readLoop(ctx, messages)
+	}()
+	go func() {
+		defer readers.Done()
+		c.claimLoop(ctx, messages)
+	}()
+
+	readers.Wait()
+	close(messages)
+	workers.Wait()
+
+	return nil
+}
+
+func (c *StreamConsumer[T]) readLoop(ctx context.Context, messages chan<- redis.XMessage) {
+	for ctx.Err() == nil {
+		streams, err := c.redis.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.conf.Group,
+			Consumer: c.conf.Consumer,
+			Streams:  []string{c.redis.key(c.conf.Stream), ">"},
+			Count:    c.conf.Count,
+			Block:    c.conf.Block,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			log.Warn("redis stream read failed", zap.String("stream", c.conf.Stream), zap.Error(err))
+			sleepContext(ctx, time.Second)
+			continue
+		}
+
+		for _, stream := range streams {
+			if !c.dispatch(ctx, messages, stream.Messages) {
+				return
+			}
+		}
+	}
+}
+
+func (c *StreamConsumer[T]) claimLoop(ctx context.Context, messages chan<- redis.XMessage) {
+	ticker := time.NewTicker(c.conf.ClaimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := "0-0"
+		for {
+			claimed, next, err := c.redis.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   c.redis.key(c.conf.Stream),
+				Group:    c.conf.Group,
+				Consumer: c.conf.Consumer,
+				MinIdle:  c.conf.ClaimIdle,
+				Start:    start,
+				Count:    c.conf.Count,
+			}).Result()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn("redis stream autoclaim failed", zap.String("stream", c.conf.Stream), zap.Error(err))
+				}
+				break
+			}
+
+			if !c.dispatch(ctx, messages, claimed) {
+				return
+			}
+
+			// a page can be empty mid scan, e.g. when it only held entries
+			// below ClaimIdle, the scan is done once redis returns 0-0
+			if next == "0-0" || len(next) == 0 {
+				break
+			}
+			start = next
+		}
+	}
+}
+
+func (c *StreamConsumer[T]) process(ctx context.Context, msg redis.XMessage) {
+	defer c.release(msg.ID)
+
+	// acks must still reach redis while the consumer is shutting down
+	ackCtx := context.WithoutCancel(ctx)
+
+	data, err := decodeStreamData[T](msg)
+	if err != nil {
+		log.Error("redis stream message is invalid, dropped", zap.String("stream", c.conf.Stream), zap.String("id", msg.ID), zap.Error(err))
+		_ = c.redis.XAck(ackCtx, c.conf.Stream, c.conf.Group, msg.ID)
+		return
+	}
+
+	if err = c.handler(ctx, &StreamMessage[T]{ID: msg.ID, Data: data}); err != nil {
+		log.Warn("redis stream handler failed", zap.String("stream", c.conf.Stream), zap.String("id", msg.ID), zap.Error(err))
+		return
+	}
+
+	if err = c.redis.XAck(ackCtx, c.conf.Stream, c.conf.Group, msg.ID); err != nil {
+		log.Warn("redis stream ack failed", zap.String("stream", c.conf.Stream), zap.String("id", msg.ID), zap.Error(err))
+	}
+}
+
+func decodeStreamData[T any](msg redis.XMessage) (T, error) {
+	var data T
+
+	raw, ok := msg.Values[streamDataField].(string)
+	if !ok {
+		return data, errors.New("stream message has no data field")
+	}
+
+	err := json.Unmarshal([]byte(raw), &data)
+	return data, err
+}
+
+// dispatch queues the messages that are not already in flight.
+func (c *StreamConsumer[T]) dispatch(ctx context.Context, messages chan<- redis.XMessage, list []redis.XMessage) bool {
+	for _, msg := range list {
+		if !c.acquire(msg.ID) {
+			continue
+		}
+
+		select {
+		case messages <- msg:
+		case <-ctx.Done():
+			c.release(msg.ID)
+			return false
+		}
+	}
+	return true
+}
+
+func (c *StreamConsumer[T]) acquire(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.inflight[id]; ok {
+		return false
+	}
+	c.inflight[id] = struct{}{}
+	return true
+}
+
+func (c *StreamConsumer[T]) release(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inflight, id)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
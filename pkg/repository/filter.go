@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"fmt"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+)
+
+// Filter builds the conditions of a typed query struct. Fields are mapped
+// with a filter tag holding the column and an operator:
+//
+//	type UserQuery struct {
+//		Name    *string  `filter:"name"`
+//		MinAge  int      `filter:"age,gte"`
+//		IDs     []int64  `filter:"id,in"`
+//		Keyword string   `filter:"name,like"`
+//		Deleted *bool    `filter:"deleted_at,null"`
+//	}
+//
+// Operators are eq (the default), ne, gt, gte, lt, lte, in, not_in, like,
+// prefix and null. Nil pointers, empty slices and zero values are skipped;
+// use a pointer to filter on a zero value.
+func Filter(query any) ([]clause.Expression, error) {
+	if query == nil {
+		return nil, nil
+	}
+
+	v := reflect.ValueOf(query)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("filter query must be a struct, got %s", v.Kind())
+	}
+
+	var exprs []clause.Expression
+	if err := appendFilter(&exprs, v); err != nil {
+		return nil, err
+	}
+	return exprs, nil
+}
+
+func appendFilter(exprs *[]clause.Expression, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+
+		tag, ok := field.Tag.Lookup("filter")
+		if !ok {
+			if field.Anonymous && value.Kind() == reflect.Struct {
+				if err := appendFilter(exprs, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		column, op, _ := strings.Cut(tag, ",")
+		if len(op) == 0 {
+			op = "eq"
+		}
+
+		if value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		} else if value.IsZero() {
+			continue
+		}
+		if (value.Kind() == reflect.Slice || value.Kind() == reflect.Array) && value.Len() == 0 {
+			continue
+		}
+
+		expr, err := condition(clause.Column{Name: column}, op, value.Interface())
+		if err != nil {
+			return fmt.Errorf("filter field %s: %w", field.Name, err)
+		}
+		*exprs = append(*exprs, expr)
+	}
+	return nil
+}
+
+func condition(column clause.Column, op string, value any) (clause.Expression, error) {
+	switch op {
+	case "eq":
+		return clause.Eq{Column: column, Value: value}, nil
+	case "ne":
+		return clause.Neq{Column: column, Value: value}, nil
+	case "gt":
+		return clause.Gt{Column: column, Value: value}, nil
+	case "gte":
+		return clause.Gte{Column: column, Value: value}, nil
+	case "lt":
+		return clause.Lt{Column: column, Value: value}, nil
+	case "lte":
+		return clause.Lte{Column: column, Value: value}, nil
+	case "in", "not_in":
+		values, err := sliceValues(value)
+		if err != nil {
+			return nil, err
+		}
+		if op == "in" {
+			return clause.IN{Column: column, Values: values}, nil
+		}
+		return clause.Not(clause.IN{Column: column, Values: values}), nil
+	case "like", "prefix":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a string", op)
+		}
+		pattern := escapeLike(s) + "%"
+		if op == "like" {
+			pattern = "%" + pattern
+		}
+		// '!' escapes the same way on mysql, postgres and sqlite
+		return clause.Expr{SQL: "? LIKE ? ESCAPE '!'", Vars: []any{column, pattern}}, nil
+	case "null":
+		isNull, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("null needs a bool")
+		}
+		if isNull {
+			return clause.Eq{Column: column, Value: nil}, nil
+		}
+		return clause.Neq{Column: column, Value: nil}, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %q", op)
+	}
+}
+
+func sliceValues(value any) ([]any, error) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("in needs a slice")
+	}
+
+	values := make([]any, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface()
+	}
+	return values, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
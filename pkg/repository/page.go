@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+const defaultLimit = 20
+
+type Order struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
+// Page selects rows by offset, simple but slow for deep pages.
+type Page struct {
+	Offset int     `json:"offset"`
+	Limit  int     `json:"limit"`
+	Order  []Order `json:"order"`
+}
+
+// Cursor selects the rows after a cursor token returned by ListAfter. Rows
+// are ordered by Order.Column, the primary key breaking ties, so the column
+// should be immutable like an id or a creation time.
+type Cursor struct {
+	After string `json:"after"`
+	Limit int    `json:"limit"`
+	Order Order  `json:"order"`
+}
+
+// List returns a page of the rows matching query together with their total
+// number.
+func (r *Repository[T]) List(ctx context.Context, query any, page Page) ([]*T, int64, error) {
+	tx, err := r.where(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// columns are looked up in the model, the names may come from a request
+	orders := make([]clause.OrderByColumn, 0, len(page.Order))
+	if len(page.Order) > 0 {
+		s, err := r.schema(tx)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, order := range page.Order {
+			field := s.LookUpField(order.Column)
+			if field == nil || len(field.DBName) == 0 {
+				return nil, 0, errors.New("unknown order column " + order.Column)
+			}
+			orders = append(orders, clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Desc: order.Desc})
+		}
+	}
+
+	var total int64
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	limit := page.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	tx = tx.Offset(page.Offset).Limit(limit)
+	for _, order := range orders {
+		tx = tx.Order(order)
+	}
+
+	var list []*T
+	if err = tx.Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// ListAfter returns the rows matching query that follow cursor.After and the
+// token for the next page, "" on the last one.
+func (r *Repository[T]) ListAfter(ctx context.Context, query any, cursor Cursor) ([]*T, string, error) {
+	tx, err := r.where(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s, err := r.schema(tx)
+	if err != nil {
+		return nil, "", err
+	}
+	pk := s.PrioritizedPrimaryField
+	if pk == nil {
+		return nil, "", errors.New("keyset pagination needs a primary key")
+	}
+
+	sortField := pk
+	if len(cursor.Order.Column) > 0 {
+		if sortField = s.LookUpField(cursor.Order.Column); sortField == nil || len(sortField.DBName) == 0 {
+			return nil, "", errors.New("unknown order column " + cursor.Order.Column)
+		}
+	}
+
+	limit := cursor.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	sortColumn := clause.Column{Table: clause.CurrentTable, Name: sortField.DBName}
+	pkColumn := clause.Column{Table: clause.CurrentTable, Name: pk.DBName}
+
+	if len(cursor.After) > 0 {
+		sortValue, pkValue, err := decodeCursor(cursor.After, sortField, pk)
+		if err != nil {
+			return nil, "", err
+		}
+
+		after := func(column clause.Column, value any) clause.Expression {
+			if cursor.Order.Desc {
+				return clause.Lt{Column: column, Value: value}
+			}
+			return clause.Gt{Column: column, Value: value}
+		}
+
+		if sortField == pk {
+			tx = tx.Where(after(pkColumn, pkValue))
+		} else {
+			tx = tx.Where(clause.Or(
+				after(sortColumn, sortValue),
+				clause.And(clause.Eq{Column: sortColumn, Value: sortValue}, after(pkColumn, pkValue)),
+			))
+		}
+	}
+
+	tx = tx.Order(clause.OrderByColumn{Column: sortColumn, Desc: cursor.Order.Desc})
+	if sortField != pk {
+		tx = tx.Order(clause.OrderByColumn{Column: pkColumn, Desc: cursor.Order.Desc})
+	}
+
+	// one more row tells whether there is a next page
+	var list []*T
+	if err = tx.Limit(limit + 1).Find(&list).Error; err != nil {
+		return nil, "", err
+	}
+	if len(list) <= limit {
+		return list, "", nil
+	}
+
+	list = list[:limit]
+	next, err := encodeCursor(ctx, list[limit-1], sortField, pk)
+	if err != nil {
+		return nil, "", err
+	}
+	return list, next, nil
+}
+
+func encodeCursor[T any](ctx context.Context, entity *T, sortField, pk *schema.Field) (string, error) {
+	row := reflect.ValueOf(entity).Elem()
+	sortValue, _ := sortField.ValueOf(ctx, row)
+	pkValue, _ := pk.ValueOf(ctx, row)
+
+	data, err := json.Marshal([]any{sortValue, pkValue})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor restores the values with the types of their fields, so they
+// compare like the columns.
+func decodeCursor(token string, sortField, pk *schema.Field) (any, any, error) {
+	invalid := errors.New("invalid cursor")
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, nil, invalid
+	}
+
+	var raw []json.RawMessage
+	if err = json.Unmarshal(data, &raw); err != nil || len(raw) != 2 {
+		return nil, nil, invalid
+	}
+
+	sortValue := reflect.New(sortField.FieldType)
+	if err = json.Unmarshal(raw[0], sortValue.Interface()); err != nil {
+		return nil, nil, invalid
+	}
+	pkValue := reflect.New(pk.FieldType)
+	if err = json.Unmarshal(raw[1], pkValue.Interface()); err != nil {
+		return nil, nil, invalid
+	}
+
+	return sortValue.Elem().Interface(), pkValue.Elem().Interface(), nil
+}
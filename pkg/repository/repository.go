@@ -0,0 +1,371 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/garfieldlw/common-golang/pkg/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+var (
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned by Update when the row was changed since it was
+	// read, i.e. its version column no longer matches.
+	ErrConflict = errors.New("record was modified concurrently")
+)
+
+// Repository implements the common queries of a gorm model T. Its queries run
+// in the transaction of db.WithTx when given its context.
+//
+// Models with a gorm.DeletedAt field are soft deleted and hidden from reads
+// unless Unscoped is used. Models with a version column, "version" unless
+// configured otherwise, are updated with optimistic locking.
+type Repository[T any] struct {
+	provider      Provider
+	versionColumn string
+	unscoped      bool
+}
+
+// Provider returns the pool a repository queries, e.g. mysql.DB.
+type Provider func() (*db.DB, error)
+
+// New returns a repository on d. A pool replaced later, like the ones of the
+// driver packages on SetConfig, is closed for it; use NewWithProvider then.
+func New[T any](d *db.DB) *Repository[T] {
+	return NewWithProvider[T](func() (*db.DB, error) {
+		return d, nil
+	})
+}
+
+// NewWithProvider returns a repository that resolves its pool with provider
+// on every call:
+//
+//	users := repository.NewWithProvider[User](mysql.DB)
+func NewWithProvider[T any](provider Provider) *Repository[T] {
+	return &Repository[T]{provider: provider, versionColumn: "version"}
+}
+
+// WithVersionColumn sets the optimistic locking column, "" turns it off.
+func (r *Repository[T]) WithVersionColumn(column string) *Repository[T] {
+	c := *r
+	c.versionColumn = column
+	return &c
+}
+
+// Unscoped returns a repository whose queries include soft deleted rows and
+// whose Delete removes rows for good.
+func (r *Repository[T]) Unscoped() *Repository[T] {
+	c := *r
+	c.unscoped = true
+	return &c
+}
+
+func (r *Repository[T]) conn(ctx context.Context) (*gorm.DB, error) {
+	d, err := r.provider()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.GetDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r.unscoped {
+		conn = conn.Unscoped()
+	}
+	return conn, nil
+}
+
+func (r *Repository[T]) schema(conn *gorm.DB) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: conn}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+func (r *Repository[T]) Get(ctx context.Context, id any) (*T, error) {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entity := new(T)
+	err = conn.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Take(entity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+// First returns the first row matching query, see Filter.
+func (r *Repository[T]) First(ctx context.Context, query any) (*T, error) {
+	tx, err := r.where(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	entity := new(T)
+	err = tx.Take(entity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+// Count returns the number of rows matching query.
+func (r *Repository[T]) Count(ctx context.Context, query any) (int64, error) {
+	tx, err := r.where(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = tx.Count(&count).Error
+	return count, err
+}
+
+func (r *Repository[T]) where(ctx context.Context, query any) (*gorm.DB, error) {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exprs, err := Filter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := conn.Model(new(T))
+	if len(exprs) > 0 {
+		tx = tx.Clauses(clause.Where{Exprs: exprs})
+	}
+	// a new session, so the conditions can be shared by a count and a find
+	return tx.Session(&gorm.Session{}), nil
+}
+
+// Create inserts entity, setting its version to 1.
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	field, err := r.versionField(conn)
+	if err != nil {
+		return err
+	}
+	if field != nil {
+		if err = setVersion(ctx, field, entity, 1); err != nil {
+			return err
+		}
+	}
+
+	return conn.Create(entity).Error
+}
+
+// Update writes entity, all columns or only the given ones. Writing all
+// columns keeps the creation time and the soft delete state. With a version
+// column the row is only written when its version still is the one of
+// entity, otherwise ErrConflict is returned; on success the version of
+// entity is incremented.
+func (r *Repository[T]) Update(ctx context.Context, entity *T, columns ...string) error {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	s, err := r.schema(conn)
+	if err != nil {
+		return err
+	}
+	if s.PrioritizedPrimaryField == nil {
+		return errors.New("update needs a primary key")
+	}
+
+	field, err := r.versionField(conn)
+	if err != nil {
+		return err
+	}
+
+	tx := conn.Model(entity)
+	switch {
+	case len(columns) == 0:
+		omit := []string{s.PrioritizedPrimaryField.Name}
+		for _, f := range s.Fields {
+			if f.AutoCreateTime > 0 || isDeletedAt(f) {
+				omit = append(omit, f.Name)
+			}
+		}
+		tx = tx.Select("*").Omit(omit...)
+	case field != nil:
+		// a copy, appending could write into the array of the caller
+		selected := make([]string, 0, len(columns)+1)
+		selected = append(selected, columns...)
+		tx = tx.Select(append(selected, field.DBName))
+	default:
+		tx = tx.Select(columns)
+	}
+
+	if field == nil {
+		return tx.Updates(entity).Error
+	}
+
+	current, err := version(ctx, field, entity)
+	if err != nil {
+		return err
+	}
+	if err = setVersion(ctx, field, entity, current+1); err != nil {
+		return err
+	}
+
+	res := tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: current}).Updates(entity)
+	if res.Error != nil || res.RowsAffected == 0 {
+		_ = setVersion(ctx, field, entity, current)
+		if res.Error != nil {
+			return res.Error
+		}
+		return ErrConflict
+	}
+	return nil
+}
+
+// Upsert inserts entity or updates every column of the row conflicting on
+// columns, the primary key by default. It does not check the version: an
+// inserted row gets version 1 like with Create, an updated one has its
+// version incremented. entity is left with version 1 either way, so reload
+// it before an Update.
+func (r *Repository[T]) Upsert(ctx context.Context, entity *T, columns ...string) error {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	s, err := r.schema(conn)
+	if err != nil {
+		return err
+	}
+	field, err := r.versionField(conn)
+	if err != nil {
+		return err
+	}
+	if field != nil {
+		if err = setVersion(ctx, field, entity, 1); err != nil {
+			return err
+		}
+	}
+
+	// the columns gorm updates with UpdateAll, the version is incremented
+	// instead of being copied from entity
+	var updates []string
+	for _, f := range s.Fields {
+		if len(f.DBName) == 0 || f.PrimaryKey || !f.Updatable || f.AutoCreateTime > 0 || (field != nil && f.DBName == field.DBName) {
+			continue
+		}
+		updates = append(updates, f.DBName)
+	}
+
+	conflict := clause.OnConflict{DoUpdates: clause.AssignmentColumns(updates)}
+	if field != nil {
+		conflict.DoUpdates = append(conflict.DoUpdates, clause.Assignment{
+			Column: clause.Column{Name: field.DBName},
+			Value:  gorm.Expr("? + 1", clause.Column{Name: field.DBName}),
+		})
+	}
+	if len(conflict.DoUpdates) == 0 {
+		conflict.DoNothing = true
+	}
+	for _, column := range columns {
+		conflict.Columns = append(conflict.Columns, clause.Column{Name: column})
+	}
+	return conn.Clauses(conflict).Create(entity).Error
+}
+
+// Delete removes the row of id, soft deleting it when the model supports it.
+func (r *Repository[T]) Delete(ctx context.Context, id any) error {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	res := conn.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Delete(new(T))
+	if res.Error == nil && res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return res.Error
+}
+
+// Restore brings back a soft deleted row.
+func (r *Repository[T]) Restore(ctx context.Context, id any) error {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	s, err := r.schema(conn)
+	if err != nil {
+		return err
+	}
+
+	var deletedAt *schema.Field
+	for _, field := range s.Fields {
+		if isDeletedAt(field) {
+			deletedAt = field
+			break
+		}
+	}
+	if deletedAt == nil {
+		return errors.New("model has no soft delete field")
+	}
+
+	res := conn.Unscoped().Model(new(T)).
+		Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).
+		Update(deletedAt.DBName, nil)
+	if res.Error == nil && res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return res.Error
+}
+
+func isDeletedAt(field *schema.Field) bool {
+	return field.FieldType == reflect.TypeOf(gorm.DeletedAt{})
+}
+
+func (r *Repository[T]) versionField(conn *gorm.DB) (*schema.Field, error) {
+	if len(r.versionColumn) == 0 {
+		return nil, nil
+	}
+
+	s, err := r.schema(conn)
+	if err != nil {
+		return nil, err
+	}
+	return s.LookUpField(r.versionColumn), nil
+}
+
+func version[T any](ctx context.Context, field *schema.Field, entity *T) (int64, error) {
+	value, _ := field.ValueOf(ctx, reflect.ValueOf(entity).Elem())
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), nil
+	default:
+		return 0, errors.New("version column must be an integer")
+	}
+}
+
+func setVersion[T any](ctx context.Context, field *schema.Field, entity *T, v int64) error {
+	return field.Set(ctx, reflect.ValueOf(entity).Elem(), v)
+}
@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/garfieldlw/common-golang/pkg/db"
+	_ "github.com/garfieldlw/common-golang/pkg/sqlite3"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	ID        int64 `gorm:"primaryKey"`
+	Name      string
+	Age       int
+	Email     string `gorm:"uniqueIndex"`
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt
+}
+
+type testUserQuery struct {
+	MinAge int `filter:"age,gte"`
+}
+
+func newTestPool(t *testing.T, name string) *db.DB {
+	t.Helper()
+
+	d, err := db.Open(name, &db.Config{Driver: "sqlite3", DSN: filepath.Join(t.TempDir(), "repository.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close(name) })
+
+	conn, err := d.GetDB(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.AutoMigrate(&testUser{}); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func newTestRepository(t *testing.T) *Repository[testUser] {
+	t.Helper()
+	return New[testUser](newTestPool(t, t.Name()))
+}
+
+func TestUpdateVersion(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+
+	u := &testUser{Name: "a", Email: "a@test"}
+	if err := r.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Version != 1 {
+		t.Fatalf("version after Create = %d, want 1", u.Version)
+	}
+	created, err := r.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := *u
+	u.Name = "b"
+	u.CreatedAt = time.Time{}
+	if err = r.Update(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Version != 2 {
+		t.Fatalf("version after Update = %d, want 2", u.Version)
+	}
+
+	stale.Name = "c"
+	if err = r.Update(ctx, &stale); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Update of a stale copy = %v, want ErrConflict", err)
+	}
+	if stale.Version != 1 {
+		t.Fatalf("version after a conflict = %d, want it unchanged", stale.Version)
+	}
+
+	got, err := r.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "b" || got.Version != 2 {
+		t.Fatalf("row = %q version %d, want b version 2", got.Name, got.Version)
+	}
+	if !got.CreatedAt.Equal(created.CreatedAt) {
+		t.Fatalf("full Update changed created_at from %s to %s", created.CreatedAt, got.CreatedAt)
+	}
+
+	// the version column is added to the selected ones without touching
+	// the array of the caller
+	columns := make([]string, 1, 2)
+	columns[0] = "name"
+	spare := columns[:2]
+	spare[1] = "untouched"
+	got.Name = "d"
+	got.Age = 40
+	if err = r.Update(ctx, got, columns...); err != nil {
+		t.Fatal(err)
+	}
+	if spare[1] != "untouched" {
+		t.Fatalf("Update wrote %q into the columns of the caller", spare[1])
+	}
+	if got, err = r.Get(ctx, u.ID); err != nil || got.Name != "d" || got.Age != 0 || got.Version != 3 {
+		t.Fatalf("row after a partial Update = %+v, %v", got, err)
+	}
+}
+
+func TestUpsertVersion(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+
+	if err := r.Upsert(ctx, &testUser{Name: "a", Email: "a@test"}, "email"); err != nil {
+		t.Fatal(err)
+	}
+	first, err := r.First(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 1 {
+		t.Fatalf("version of an inserted row = %d, want 1", first.Version)
+	}
+
+	// the version of the entity must not be copied into the row
+	if err = r.Upsert(ctx, &testUser{Name: "b", Email: "a@test"}, "email"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Get(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "b" || got.Version != 2 {
+		t.Fatalf("row after the upsert = %q version %d, want b version 2", got.Name, got.Version)
+	}
+	if !got.CreatedAt.Equal(first.CreatedAt) {
+		t.Fatal("Upsert changed created_at")
+	}
+
+	got.Name = "c"
+	if err = r.Update(ctx, got); err != nil {
+		t.Fatalf("Update after an upsert = %v", err)
+	}
+}
+
+func TestListOrder(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+
+	for i, name := range []string{"a", "b", "c"} {
+		if err := r.Create(ctx, &testUser{Name: name, Age: 20 + i, Email: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, total, err := r.List(ctx, &testUserQuery{MinAge: 21}, Page{Limit: 10, Order: []Order{{Column: "Age", Desc: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(list) != 2 || list[0].Name != "c" || list[1].Name != "b" {
+		t.Fatalf("List = %d of %d rows, want c and b", len(list), total)
+	}
+
+	for _, column := range []string{"age; DROP TABLE test_users", "missing"} {
+		if _, _, err = r.List(ctx, nil, Page{Order: []Order{{Column: column}}}); err == nil {
+			t.Fatalf("List ordered by %q succeeded", column)
+		}
+	}
+}
+
+func TestListAfter(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+
+	// ties on age are broken by the primary key
+	ages := []int{30, 10, 20, 10, 30, 20, 10}
+	for i, age := range ages {
+		if err := r.Create(ctx, &testUser{Name: string(rune('a' + i)), Age: age, Email: string(rune('a' + i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, desc := range []bool{false, true} {
+		var names string
+		cursor := Cursor{Limit: 2, Order: Order{Column: "age", Desc: desc}}
+		for pages := 0; ; pages++ {
+			if pages > len(ages) {
+				t.Fatal("ListAfter did not end")
+			}
+
+			list, next, err := r.ListAfter(ctx, nil, cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, u := range list {
+				names += u.Name
+			}
+			if len(next) == 0 {
+				break
+			}
+			cursor.After = next
+		}
+
+		want := "bdgcfae"
+		if desc {
+			want = "eafcgdb"
+		}
+		if names != want {
+			t.Fatalf("ListAfter desc=%v = %s, want %s", desc, names, want)
+		}
+	}
+
+	if _, _, err := r.ListAfter(ctx, nil, Cursor{After: "not a cursor"}); err == nil {
+		t.Fatal("ListAfter with an invalid cursor succeeded")
+	}
+	if _, _, err := r.ListAfter(ctx, nil, Cursor{Order: Order{Column: "missing"}}); err == nil {
+		t.Fatal("ListAfter ordered by an unknown column succeeded")
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+
+	u := &testUser{Name: "a", Email: "a"}
+	if err := r.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ctx, u.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of a deleted row = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Unscoped().Get(ctx, u.ID); err != nil {
+		t.Fatalf("unscoped Get of a deleted row = %v", err)
+	}
+	if err := r.Delete(ctx, u.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Delete of a deleted row = %v, want ErrNotFound", err)
+	}
+
+	if err := r.Restore(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ctx, u.ID); err != nil {
+		t.Fatalf("Get of a restored row = %v", err)
+	}
+
+	if err := r.Unscoped().Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Restore(ctx, u.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Restore of a removed row = %v, want ErrNotFound", err)
+	}
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	pool := newTestPool(t, t.Name()+"-1")
+	r := NewWithProvider[testUser](func() (*db.DB, error) {
+		return pool, nil
+	})
+	if err := r.Create(ctx, &testUser{Name: "a", Email: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pool is replaced and the old one closed, like on SetConfig
+	old := pool
+	pool = newTestPool(t, t.Name()+"-2")
+	_ = old.Close()
+
+	if err := r.Create(ctx, &testUser{Name: "b", Email: "b"}); err != nil {
+		t.Fatalf("Create after the pool was replaced = %v", err)
+	}
+	if n, err := r.Count(ctx, nil); err != nil || n != 1 {
+		t.Fatalf("Count on the new pool = %d, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type contextKey struct{}
+
+// FromContext returns the session loaded by Middleware, nil when the request
+// has none.
+func FromContext(ctx context.Context) *Session {
+	sess, _ := ctx.Value(contextKey{}).(*Session)
+	return sess
+}
+
+// Middleware loads the session named by the cookie, slides its expiry and
+// stores it in the request context. Requests without a valid session pass
+// through without one, and a stale cookie is cleared.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(s.conf.CookieName)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		sess, err := s.Load(ctx, cookie.Value)
+		if err == nil {
+			err = s.Touch(ctx, sess)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) {
+				log.Warn("load session failed", zap.Error(err))
+			}
+			s.clearCookie(w)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, contextKey{}, sess)))
+	})
+}
+
+// Login creates a session for userID and sends its cookie.
+func (s *Store) Login(w http.ResponseWriter, r *http.Request, userID string) (*Session, error) {
+	if old := FromContext(r.Context()); old != nil {
+		// a fresh id on login prevents session fixation
+		_ = s.Destroy(r.Context(), old)
+	}
+
+	sess, err := s.Create(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, s.cookie(sess.ID, int(s.conf.AbsoluteTimeout.Seconds())))
+	return sess, nil
+}
+
+// Logout destroys the request's session and clears the cookie.
+func (s *Store) Logout(w http.ResponseWriter, r *http.Request) error {
+	s.clearCookie(w)
+
+	sess := FromContext(r.Context())
+	if sess == nil {
+		return nil
+	}
+	return s.Destroy(r.Context(), sess)
+}
+
+func (s *Store) clearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, s.cookie("", -1))
+}
+
+func (s *Store) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.conf.CookieName,
+		Value:    value,
+		Path:     s.conf.CookiePath,
+		Domain:   s.conf.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   !s.conf.Insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,288 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/garfieldlw/common-golang/pkg/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	fieldUser      = "_uid"
+	fieldCreated   = "_created"
+	fieldAccessed  = "_accessed"
+	attrPrefix     = "a:"
+	sessionKeyPart = "s:"
+	userKeyPart    = "u:"
+)
+
+var (
+	ErrNotFound = errors.New("session not found")
+	ErrExpired  = errors.New("session expired")
+)
+
+type Config struct {
+	// Prefix namespaces the session keys in redis.
+	Prefix string
+	// IdleTimeout ends a session that is not used for this long.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends a session this long after it was created,
+	// regardless of activity.
+	AbsoluteTimeout time.Duration
+
+	CookieName   string
+	CookieDomain string
+	CookiePath   string
+	// Insecure allows the cookie over plain http, for local development only.
+	Insecure bool
+}
+
+// Store keeps sessions in redis hashes. Each session is a hash holding its
+// owner, timestamps and attributes; a sorted set per user indexes the
+// user's sessions so they can be listed and revoked together.
+type Store struct {
+	redis *redis.Redis
+	conf  Config
+}
+
+// Session is a loaded session. Attribute changes are written through with
+// Store.Set and Store.Del.
+type Session struct {
+	ID         string
+	UserID     string
+	CreatedAt  time.Time
+	AccessedAt time.Time
+
+	values map[string]string
+}
+
+func NewStore(r *redis.Redis, conf Config) *Store {
+	if len(conf.Prefix) == 0 {
+		conf.Prefix = "session"
+	}
+	if conf.IdleTimeout <= 0 {
+		conf.IdleTimeout = 30 * time.Minute
+	}
+	if conf.AbsoluteTimeout <= 0 {
+		conf.AbsoluteTimeout = 7 * 24 * time.Hour
+	}
+	if len(conf.CookieName) == 0 {
+		conf.CookieName = "sid"
+	}
+	if len(conf.CookiePath) == 0 {
+		conf.CookiePath = "/"
+	}
+
+	return &Store{redis: r.Namespace(conf.Prefix), conf: conf}
+}
+
+// Create starts a new session for userID.
+func (s *Store) Create(ctx context.Context, userID string) (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sess := &Session{ID: id, UserID: userID, CreatedAt: now, AccessedAt: now, values: map[string]string{}}
+
+	key := sessionKeyPart + id
+	userKey := userKeyPart + userID
+	_, err = s.redis.Batch().
+		HSet(key, fieldUser, userID, 0).
+		HSet(key, fieldCreated, formatTime(now), 0).
+		HSet(key, fieldAccessed, formatTime(now), s.conf.IdleTimeout).
+		ZAdd(userKey, float64(now.Unix()), id).
+		Expire(userKey, s.conf.AbsoluteTimeout).
+		ExecTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// Load returns the session with id. An idle or too old session is destroyed
+// and ErrExpired is returned.
+func (s *Store) Load(ctx context.Context, id string) (*Session, error) {
+	if len(id) == 0 {
+		return nil, ErrNotFound
+	}
+
+	values, err := s.redis.HGetAll(ctx, sessionKeyPart+id)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+
+	sess := &Session{
+		ID:         id,
+		UserID:     values[fieldUser],
+		CreatedAt:  parseTime(values[fieldCreated]),
+		AccessedAt: parseTime(values[fieldAccessed]),
+		values:     make(map[string]string, len(values)),
+	}
+	for field, value := range values {
+		if strings.HasPrefix(field, attrPrefix) {
+			sess.values[strings.TrimPrefix(field, attrPrefix)] = value
+		}
+	}
+
+	now := time.Now()
+	if now.Sub(sess.CreatedAt) >= s.conf.AbsoluteTimeout || now.Sub(sess.AccessedAt) >= s.conf.IdleTimeout {
+		_ = s.Destroy(ctx, sess)
+		return nil, ErrExpired
+	}
+
+	return sess, nil
+}
+
+// Touch records activity on the session and slides its idle expiry, never
+// past the absolute timeout. ErrNotFound is returned once the session expired
+// or was destroyed.
+func (s *Store) Touch(ctx context.Context, sess *Session) error {
+	now := time.Now()
+
+	expire := s.conf.IdleTimeout
+	if remaining := sess.CreatedAt.Add(s.conf.AbsoluteTimeout).Sub(now); remaining < expire {
+		expire = remaining
+	}
+	if expire <= 0 {
+		_ = s.Destroy(ctx, sess)
+		return ErrExpired
+	}
+
+	ok, err := s.redis.HSetIfExists(ctx, sessionKeyPart+sess.ID, fieldAccessed, formatTime(now), expire)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+
+	sess.AccessedAt = now
+	return nil
+}
+
+func (s *Store) Destroy(ctx context.Context, sess *Session) error {
+	_, err := s.redis.Batch().
+		Del(sessionKeyPart+sess.ID).
+		ZRem(userKeyPart+sess.UserID, sess.ID).
+		Exec(ctx)
+	return err
+}
+
+// Set stores value, JSON encoded, as the attribute name. ErrNotFound is
+// returned once the session expired or was destroyed.
+func (s *Store) Set(ctx context.Context, sess *Session, name string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	// a plain HSET would bring back a revoked session without expiry
+	ok, err := s.redis.HSetIfExists(ctx, sessionKeyPart+sess.ID, attrPrefix+name, string(data), 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+
+	sess.values[name] = string(data)
+	return nil
+}
+
+func (s *Store) Del(ctx context.Context, sess *Session, name string) error {
+	if err := s.redis.HDel(ctx, sessionKeyPart+sess.ID, attrPrefix+name); err != nil {
+		return err
+	}
+
+	delete(sess.values, name)
+	return nil
+}
+
+// List returns the live sessions of userID, oldest first. Index entries of
+// sessions that already expired are cleaned up on the way.
+func (s *Store) List(ctx context.Context, userID string) ([]*Session, error) {
+	userKey := userKeyPart + userID
+	ids, err := s.redis.ZRange(ctx, userKey, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Session
+	var stale []any
+	for _, id := range ids {
+		sess, err := s.Load(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrExpired) {
+				stale = append(stale, id)
+				continue
+			}
+			return nil, err
+		}
+		list = append(list, sess)
+	}
+
+	if len(stale) > 0 {
+		_ = s.redis.ZRem(ctx, userKey, stale...)
+	}
+
+	return list, nil
+}
+
+// Revoke destroys every session of userID, e.g. after a password change.
+func (s *Store) Revoke(ctx context.Context, userID string) error {
+	userKey := userKeyPart + userID
+	ids, err := s.redis.ZRange(ctx, userKey, 0, -1)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		keys = append(keys, sessionKeyPart+id)
+	}
+	keys = append(keys, userKey)
+
+	_, err = s.redis.Batch().Del(keys...).Exec(ctx)
+	return err
+}
+
+// Get decodes the attribute name of sess into a T.
+func Get[T any](sess *Session, name string) (T, bool, error) {
+	var v T
+
+	raw, ok := sess.values[name]
+	if !ok {
+		return v, false, nil
+	}
+
+	err := json.Unmarshal([]byte(raw), &v)
+	return v, err == nil, err
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func parseTime(s string) time.Time {
+	ms, _ := strconv.ParseInt(s, 10, 64)
+	return time.UnixMilli(ms)
+}
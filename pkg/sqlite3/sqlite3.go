@@ -1,91 +1,72 @@
 package sqlite3
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/garfieldlw/common-golang/pkg/db"
+	"github.com/mattn/go-sqlite3"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/plugin/dbresolver"
-	"sync"
-	"time"
 )
 
-type DbInfo struct {
-	DbConfig *ConfigItem
-	Conn     *gorm.DB
-}
-
-var instance *DbInfo
-var lock = &sync.Mutex{}
-
-func LoadDb() *DbInfo {
-	if instance != nil {
-		return instance
-	}
+// dbName is the name the pool is registered under in package db.
+const dbName = "sqlite3"
 
-	lock.Lock()
-	defer lock.Unlock()
+var instance = db.NewInstance(dbName, (*ConfigItem).dbConfig, defaultConfig)
 
-	if instance == nil {
-		dbConf := getDbConfig()
-		if dbConf == nil {
-			return nil
-		}
+func init() {
+	db.RegisterDriver("sqlite3", func(dsn string) gorm.Dialector {
+		return sqlite.Open(dsn)
+	})
+	db.RegisterRetryable("sqlite3", func(err error) bool {
+		var e sqlite3.Error
+		return errors.As(err, &e) && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+	})
+}
 
-		dnInfo := new(DbInfo)
-		dnInfo.DbConfig = dbConf
-		dnInfo.InitConnect()
+type DbInfo = db.Info[ConfigItem]
 
-		instance = dnInfo
-	}
+// DB returns the connection pool, e.g. for repository.NewWithProvider. It
+// is replaced by SetConfig, so it should be fetched per use.
+func DB() (*db.DB, error) {
+	return instance.DB()
+}
 
-	return instance
+// GetDB returns the gorm handle bound to ctx, connecting first if needed,
+// or the reason it could not connect.
+func GetDB(ctx context.Context) (*gorm.DB, error) {
+	return instance.GetDB(ctx)
 }
 
-func (info *DbInfo) InitConnect() {
-	dbConf := info.DbConfig
+// State is the readiness of the connection pool, for health checks.
+func State() db.State {
+	return instance.State()
+}
 
-	dsn := fmt.Sprintf("%s:%s?cache=shared&mode=rwc", dbConf.Type, dbConf.Path)
-	if len(dbConf.User) > 0 {
-		dsn = fmt.Sprintf("%s&_auth&_auth_user=%s&_auth_pass=%s", dsn, dbConf.User, dbConf.Password)
-	}
+// Err returns the error of the last failed connection attempt or ping.
+func Err() error {
+	return instance.Err()
+}
 
-	if db, e := gorm.Open(sqlite.Open(dsn), &gorm.Config{}); e != nil {
-		info.Conn = nil
-	} else {
-		if dbConf.Idle == 0 {
-			dbConf.Idle = 5
-		}
-		if dbConf.Open == 0 {
-			dbConf.Open = 20
-		}
-		_ = db.Use(dbresolver.Register(dbresolver.Config{}).SetMaxIdleConns(int(dbConf.Idle)).SetMaxOpenConns(int(dbConf.Open)).SetConnMaxLifetime(time.Second * 3300))
-		db = db.Session(&gorm.Session{})
-		info.Conn = db
-	}
+// WithTx runs fn in a transaction, see db.DB.WithTx. GetDB with the context
+// passed to fn returns the transaction.
+func WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return instance.WithTx(ctx, fn)
 }
 
-func (info *DbInfo) CheckAndReturnConn() *gorm.DB {
-	if info.Conn == nil {
-		lock.Lock()
-		defer lock.Unlock()
-		if info.Conn == nil || info.Conn.Error != nil {
-			info.InitConnect()
-		}
-	}
-	if info.Conn == nil {
-		return nil
-	}
+func Ping() error {
+	return instance.Ping(context.Background())
+}
 
-	return info.Conn
+// Deprecated: use GetDB, which reports why it could not connect.
+func LoadDb() *DbInfo {
+	return instance.LoadDb()
 }
 
+// Deprecated: use GetDB, which reports why it could not connect.
 func GetDb() *gorm.DB {
-	ins := LoadDb()
-	if ins == nil {
-		return nil
-	}
-	return ins.CheckAndReturnConn()
+	return instance.GetDb()
 }
 
 type ConfigItem struct {
@@ -95,9 +76,45 @@ type ConfigItem struct {
 	Password string `json:"password"`
 	Open     int64  `json:"open"`
 	Idle     int64  `json:"idle"`
+	// MaxLifetime and MaxIdleTime in seconds, see db.Config.
+	MaxLifetime int64 `json:"max_lifetime"`
+	MaxIdleTime int64 `json:"max_idle_time"`
+	// Retries and TxRetries, see db.Config.
+	Retries   int64 `json:"retries"`
+	TxRetries int64 `json:"tx_retries"`
+	// SlowThreshold in milliseconds and LogParams, see db.Config.
+	SlowThreshold int64 `json:"slow_threshold"`
+	LogParams     bool  `json:"log_params"`
+}
+
+func (conf *ConfigItem) dbConfig() *db.Config {
+	dsn := fmt.Sprintf("%s:%s?cache=shared&mode=rwc", conf.Type, conf.Path)
+	if len(conf.User) > 0 {
+		dsn = fmt.Sprintf("%s&_auth&_auth_user=%s&_auth_pass=%s", dsn, conf.User, conf.Password)
+	}
+
+	return &db.Config{
+		Driver:        "sqlite3",
+		DSN:           dsn,
+		Open:          conf.Open,
+		Idle:          conf.Idle,
+		MaxLifetime:   conf.MaxLifetime,
+		MaxIdleTime:   conf.MaxIdleTime,
+		Retries:       conf.Retries,
+		TxRetries:     conf.TxRetries,
+		SlowThreshold: conf.SlowThreshold,
+		LogParams:     conf.LogParams,
+	}
+}
+
+// SetConfig replaces the database configuration, e.g. from a config.Value
+// subscriber. The current connection pool is closed and the next GetDb call
+// connects with conf.
+func SetConfig(conf *ConfigItem) {
+	instance.SetConfig(conf)
 }
 
-func getDbConfig() *ConfigItem {
+func defaultConfig() *ConfigItem {
 	return &ConfigItem{
 		Type:     "file",
 		Path:     "db.sqlite3",
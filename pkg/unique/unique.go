@@ -27,6 +27,9 @@ type Snowflake struct {
 	worker    int64
 	sequence  int64
 	bizType   int64
+
+	claim  Worker
+	closed bool
 }
 
 func NewSnowflake(bizType BizType) (*Snowflake, error) {
@@ -44,11 +47,54 @@ func NewSnowflake(bizType BizType) (*Snowflake, error) {
 	}, nil
 }
 
-// Generate creates and returns a unique snowflake ID
+// NewSnowflakeWithWorker generates ids with a worker id claimed through
+// ClaimEtcdWorker or ClaimRedisWorker. It stops generating once the claim is
+// lost, and Close releases it.
+func NewSnowflakeWithWorker(bizType BizType, w Worker) (*Snowflake, error) {
+	if w == nil || w.ID() < 0 || w.ID() > workerMax {
+		return nil, errors.New("invalid worker id")
+	}
+
+	return &Snowflake{
+		timestamp: 0,
+		worker:    w.ID(),
+		sequence:  0,
+		bizType:   int64(bizType),
+		claim:     w,
+	}, nil
+}
+
+// Generate creates and returns a unique snowflake ID. A snowflake of
+// NewSnowflakeWithWorker panics once its worker id is lost, e.g. during a
+// network partition, rather than returning an id that may not be unique.
+//
+// Deprecated: use GenerateE, which returns ErrWorkerLost instead of
+// panicking.
 func (s *Snowflake) Generate() int64 {
+	id, err := s.GenerateE()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// GenerateE creates and returns a unique snowflake ID, or ErrWorkerLost when
+// the claimed worker id expired or was released.
+func (s *Snowflake) GenerateE() (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.closed {
+		return 0, ErrWorkerLost
+	}
+	if s.claim != nil {
+		select {
+		case <-s.claim.Lost():
+			return 0, ErrWorkerLost
+		default:
+		}
+	}
+
 	now := time.Now().UnixNano() / 1e6
 
 	if s.timestamp == now {
@@ -65,7 +111,23 @@ func (s *Snowflake) Generate() int64 {
 
 	s.timestamp = now
 
-	return ((now-epoch)&0x01FFFFFFFFFF)<<timestampShift | (s.worker << workerShift) | (s.sequence << sequenceShift) | s.bizType<<typeShift
+	return ((now-epoch)&0x01FFFFFFFFFF)<<timestampShift | (s.worker << workerShift) | (s.sequence << sequenceShift) | s.bizType<<typeShift, nil
+}
+
+// Close releases the claimed worker id. The Snowflake must not be used after.
+func (s *Snowflake) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if s.claim == nil {
+		return nil
+	}
+	return s.claim.Close()
 }
 
 func (s *Snowflake) GetBizType(id int64) BizType {
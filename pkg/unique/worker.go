@@ -0,0 +1,237 @@
+package unique
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/garfieldlw/common-golang/pkg/etcd"
+	"github.com/garfieldlw/common-golang/pkg/log"
+	"github.com/garfieldlw/common-golang/pkg/redis"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrWorkerLost = errors.New("snowflake worker id lost")
+
+// Worker is a worker id claimed in a shared store, so no two running
+// processes generate ids with the same one.
+type Worker interface {
+	ID() int64
+	// Lost is closed when the claim could not be renewed and is about to
+	// expire, before another process can hold the id.
+	Lost() <-chan struct{}
+	// Close releases the id.
+	Close() error
+}
+
+// ClaimEtcdWorker claims a free worker id under prefix, attached to a lease
+// with ttl that is kept alive until Close.
+func ClaimEtcdWorker(ctx context.Context, e *etcd.Etcd, prefix string, ttl time.Duration) (Worker, error) {
+	if ttl <= 0 {
+		ttl = 15 * time.Second
+	}
+
+	session, err := e.NewSession(ctx, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := workerOwner()
+	start := hostnameToInt() & workerMax
+	for i := int64(0); i <= workerMax; i++ {
+		id := (start + i) & workerMax
+
+		ok, err := session.PutIfAbsent(ctx, fmt.Sprintf("%s/%d", prefix, id), owner)
+		if err != nil {
+			_ = session.Close()
+			return nil, err
+		}
+		if ok {
+			w := &etcdWorker{
+				id:      id,
+				session: session,
+				ttl:     ttl,
+				lost:    make(chan struct{}),
+				stop:    make(chan struct{}),
+				done:    make(chan struct{}),
+			}
+			go w.watch()
+
+			return w, nil
+		}
+	}
+
+	_ = session.Close()
+	return nil, errors.New("no free snowflake worker id")
+}
+
+type etcdWorker struct {
+	id      int64
+	session *etcd.Session
+	ttl     time.Duration
+
+	lost      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// watch declares the claim lost once the lease was not kept alive for 2/3 of
+// its ttl, like redisWorker. Session.Lost only closes after the lease expired
+// on the server, when another process may already hold the id.
+func (w *etcdWorker) watch() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.ttl / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.session.Lost():
+		case <-ticker.C:
+			if time.Until(w.session.Expiry()) > w.ttl/3 {
+				continue
+			}
+		}
+
+		log.Error("snowflake worker id lost", zap.Int64("worker", w.id))
+		close(w.lost)
+		return
+	}
+}
+
+func (w *etcdWorker) ID() int64 {
+	return w.id
+}
+
+func (w *etcdWorker) Lost() <-chan struct{} {
+	return w.lost
+}
+
+func (w *etcdWorker) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+		err = w.session.Close()
+	})
+	return err
+}
+
+// ClaimRedisWorker claims a free worker id under prefix as a key with ttl
+// that is renewed in the background until Close. The claim is considered
+// lost when it could not be renewed before it expired.
+func ClaimRedisWorker(ctx context.Context, r *redis.Redis, prefix string, ttl time.Duration) (Worker, error) {
+	if ttl <= 0 {
+		ttl = 15 * time.Second
+	}
+
+	token := fmt.Sprintf("%s:%d", workerOwner(), time.Now().UnixNano())
+	start := hostnameToInt() & workerMax
+	for i := int64(0); i <= workerMax; i++ {
+		id := (start + i) & workerMax
+		key := fmt.Sprintf("%s:%d", prefix, id)
+
+		ok, err := r.SetNX(ctx, key, token, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		keepCtx, cancel := context.WithCancel(context.Background())
+		w := &redisWorker{
+			redis:  r,
+			key:    key,
+			token:  token,
+			id:     id,
+			ttl:    ttl,
+			cancel: cancel,
+			lost:   make(chan struct{}),
+			done:   make(chan struct{}),
+		}
+		go w.keepAlive(keepCtx)
+
+		return w, nil
+	}
+
+	return nil, errors.New("no free snowflake worker id")
+}
+
+type redisWorker struct {
+	redis *redis.Redis
+	key   string
+	token string
+	id    int64
+	ttl   time.Duration
+
+	cancel    context.CancelFunc
+	lost      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (w *redisWorker) keepAlive(ctx context.Context) {
+	defer close(w.done)
+
+	interval := w.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		held, err := w.redis.ExpireIfValue(ctx, w.key, w.token, w.ttl)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && held {
+			renewedAt = time.Now()
+			continue
+		}
+		if err != nil {
+			log.Warn("snowflake worker renew failed", zap.String("key", w.key), zap.Error(err))
+			// keep trying while the next attempt still lands before expiry
+			if time.Since(renewedAt)+interval < w.ttl {
+				continue
+			}
+		}
+
+		log.Error("snowflake worker id lost", zap.String("key", w.key), zap.Int64("worker", w.id))
+		close(w.lost)
+		return
+	}
+}
+
+func (w *redisWorker) ID() int64 {
+	return w.id
+}
+
+func (w *redisWorker) Lost() <-chan struct{} {
+	return w.lost
+}
+
+func (w *redisWorker) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		w.cancel()
+		<-w.done
+		_, err = w.redis.DelIfValue(context.Background(), w.key, w.token)
+	})
+	return err
+}
+
+func workerOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}